@@ -0,0 +1,186 @@
+package accounting
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Grouping chọn các trường dùng để gom lưu lượng khi xuất báo cáo
+type Grouping struct {
+    User     bool
+    Port     bool
+    Upstream bool
+}
+
+// ParseGrouping đọc danh sách trường cách nhau bởi dấu phẩy (user,port,upstream),
+// chuỗi rỗng gom theo cả ba trường
+func ParseGrouping(s string) (Grouping, error) {
+    if s == "" {
+        return Grouping{User: true, Port: true, Upstream: true}, nil
+    }
+
+    var g Grouping
+    for _, field := range strings.Split(s, ",") {
+        switch strings.TrimSpace(field) {
+        case "user":
+            g.User = true
+        case "port":
+            g.Port = true
+        case "upstream":
+            g.Upstream = true
+        default:
+            return g, fmt.Errorf("unknown group field %q (expected user, port or upstream)", field)
+        }
+    }
+    return g, nil
+}
+
+// ParseTime đọc thời điểm dạng RFC 3339 hoặc ngày YYYY-MM-DD (UTC)
+func ParseTime(s string) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t, nil
+    }
+    t, err := time.Parse("2006-01-02", s)
+    if err != nil {
+        return t, fmt.Errorf("invalid time %q (expected RFC 3339 or YYYY-MM-DD)", s)
+    }
+    return t, nil
+}
+
+// Window đọc khoảng [from, to) của báo cáo. to mặc định là cuối giờ hiện tại,
+// from mặc định là 24 giờ trước to
+func Window(fromStr, toStr string) (time.Time, time.Time, error) {
+    to := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+    if toStr != "" {
+        t, err := ParseTime(toStr)
+        if err != nil {
+            return t, t, err
+        }
+        to = t
+    }
+
+    from := to.Add(-24 * time.Hour)
+    if fromStr != "" {
+        t, err := ParseTime(fromStr)
+        if err != nil {
+            return t, t, err
+        }
+        from = t
+    }
+    return from, to, nil
+}
+
+// Record là tổng lưu lượng của một nhóm trong khoảng thời gian,
+// trường không dùng để gom có giá trị rỗng
+type Record struct {
+    User     string `json:"user,omitempty"`
+    Port     int    `json:"port,omitempty"`
+    Upstream string `json:"upstream,omitempty"`
+    Usage
+}
+
+// Query gom lưu lượng trong các giờ thuộc [from, to)
+func (l *Ledger) Query(from, to time.Time, by Grouping) []Record {
+    l.mu.Lock()
+    buckets := l.snapshotLocked()
+    l.mu.Unlock()
+
+    return aggregate(buckets, from, to, by)
+}
+
+// QueryFile giống Query nhưng đọc trực tiếp file lưu lượng, dùng khi server không chạy
+func QueryFile(path string, from, to time.Time, by Grouping) ([]Record, error) {
+    buckets, err := readFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return aggregate(buckets, from, to, by), nil
+}
+
+func aggregate(buckets []bucket, from, to time.Time, by Grouping) []Record {
+    totals := make(map[Key]*Usage)
+    for _, b := range buckets {
+        if b.Hour.Before(from) || !b.Hour.Before(to) {
+            continue
+        }
+
+        var key Key
+        if by.User {
+            key.User = b.User
+        }
+        if by.Port {
+            key.Port = b.Port
+        }
+        if by.Upstream {
+            key.Upstream = b.Upstream
+        }
+
+        u, ok := totals[key]
+        if !ok {
+            u = &Usage{}
+            totals[key] = u
+        }
+        u.add(b.Usage)
+    }
+
+    records := make([]Record, 0, len(totals))
+    for key, u := range totals {
+        records = append(records, Record{User: key.User, Port: key.Port, Upstream: key.Upstream, Usage: *u})
+    }
+    sort.Slice(records, func(i, j int) bool {
+        a, b := records[i], records[j]
+        if a.User != b.User {
+            return a.User < b.User
+        }
+        if a.Port != b.Port {
+            return a.Port < b.Port
+        }
+        return a.Upstream < b.Upstream
+    })
+    return records
+}
+
+// Export ghi báo cáo ở định dạng csv hoặc json
+func Export(w io.Writer, format string, from, to time.Time, records []Record) error {
+    switch format {
+    case "csv":
+        return writeCSV(w, from, to, records)
+    case "json":
+        return json.NewEncoder(w).Encode(struct {
+            From    time.Time `json:"from"`
+            To      time.Time `json:"to"`
+            Records []Record  `json:"records"`
+        }{from, to, records})
+    }
+    return fmt.Errorf("unknown format %q (expected csv or json)", format)
+}
+
+func writeCSV(w io.Writer, from, to time.Time, records []Record) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"from", "to", "user", "port", "upstream", "requests", "bytes_in", "bytes_out"})
+
+    for _, r := range records {
+        port := ""
+        if r.Port != 0 {
+            port = strconv.Itoa(r.Port)
+        }
+        cw.Write([]string{
+            from.Format(time.RFC3339),
+            to.Format(time.RFC3339),
+            r.User,
+            port,
+            r.Upstream,
+            strconv.FormatInt(r.Requests, 10),
+            strconv.FormatInt(r.BytesIn, 10),
+            strconv.FormatInt(r.BytesOut, 10),
+        })
+    }
+    cw.Flush()
+    return cw.Error()
+}
@@ -0,0 +1,208 @@
+package accounting
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// fileVersion là phiên bản định dạng file lưu lưu lượng
+const fileVersion = 1
+
+// Key xác định nơi lưu lượng được tính: tài khoản, HTTP port của listener và upstream
+type Key struct {
+    User     string
+    Port     int
+    Upstream string
+}
+
+// Usage là lưu lượng cộng dồn. BytesIn là dữ liệu client gửi lên (upload),
+// BytesOut là dữ liệu trả về client (download)
+type Usage struct {
+    Requests int64 `json:"requests"`
+    BytesIn  int64 `json:"bytes_in"`
+    BytesOut int64 `json:"bytes_out"`
+}
+
+func (u *Usage) add(other Usage) {
+    u.Requests += other.Requests
+    u.BytesIn += other.BytesIn
+    u.BytesOut += other.BytesOut
+}
+
+// bucketKey là lưu lượng của một Key trong một giờ
+type bucketKey struct {
+    Hour int64
+    Key
+}
+
+// bucket là một dòng trong file lưu lượng
+type bucket struct {
+    Hour     time.Time `json:"hour"`
+    User     string    `json:"user"`
+    Port     int       `json:"port"`
+    Upstream string    `json:"upstream"`
+    Usage
+}
+
+type ledgerFile struct {
+    Version int      `json:"version"`
+    Buckets []bucket `json:"buckets"`
+}
+
+// Ledger ghi nhận lưu lượng theo giờ cho từng tài khoản, port và upstream,
+// ghi xuống file định kỳ và khi Close để số liệu còn sau khi khởi động lại
+type Ledger struct {
+    config config.AccountingConfig
+
+    mu      sync.Mutex
+    buckets map[bucketKey]*Usage
+    dirty   bool
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+// Open đọc file lưu lượng (nếu đã có), gọi Start để bắt đầu ghi định kỳ
+func Open(cfg config.AccountingConfig) (*Ledger, error) {
+    l := &Ledger{
+        config:  cfg,
+        buckets: make(map[bucketKey]*Usage),
+    }
+    if err := l.load(); err != nil {
+        return nil, err
+    }
+    return l, nil
+}
+
+// Add cộng lưu lượng vào giờ hiện tại
+func (l *Ledger) Add(key Key, usage Usage) {
+    hour := time.Now().UTC().Truncate(time.Hour).Unix()
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    bk := bucketKey{Hour: hour, Key: key}
+    u, ok := l.buckets[bk]
+    if !ok {
+        u = &Usage{}
+        l.buckets[bk] = u
+    }
+    u.add(usage)
+    l.dirty = true
+}
+
+// Start ghi lưu lượng xuống file sau mỗi FlushInterval
+func (l *Ledger) Start() {
+    l.stop = make(chan struct{})
+    l.done = make(chan struct{})
+
+    go func() {
+        defer close(l.done)
+
+        ticker := time.NewTicker(l.config.FlushInterval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-l.stop:
+                return
+            case <-ticker.C:
+                if err := l.Flush(); err != nil {
+                    utils.GetLogger().Error("Failed to save traffic accounting", zap.String("file", l.config.File), zap.Error(err))
+                }
+            }
+        }
+    }()
+}
+
+// Close dừng ghi định kỳ và ghi lần cuối
+func (l *Ledger) Close() error {
+    if l.stop != nil {
+        close(l.stop)
+        <-l.done
+    }
+    return l.Flush()
+}
+
+// Flush bỏ các giờ cũ hơn Retention rồi ghi file nếu có thay đổi
+func (l *Ledger) Flush() error {
+    l.mu.Lock()
+    if l.config.Retention > 0 {
+        cutoff := time.Now().Add(-l.config.Retention).Unix()
+        for bk := range l.buckets {
+            if bk.Hour < cutoff {
+                delete(l.buckets, bk)
+                l.dirty = true
+            }
+        }
+    }
+    if !l.dirty {
+        l.mu.Unlock()
+        return nil
+    }
+    data := ledgerFile{Version: fileVersion, Buckets: l.snapshotLocked()}
+    l.dirty = false
+    l.mu.Unlock()
+
+    encoded, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+
+    return utils.WriteFileAtomic(l.config.File, encoded)
+}
+
+func (l *Ledger) snapshotLocked() []bucket {
+    buckets := make([]bucket, 0, len(l.buckets))
+    for bk, u := range l.buckets {
+        buckets = append(buckets, bucket{
+            Hour:     time.Unix(bk.Hour, 0).UTC(),
+            User:     bk.User,
+            Port:     bk.Port,
+            Upstream: bk.Upstream,
+            Usage:    *u,
+        })
+    }
+    return buckets
+}
+
+func (l *Ledger) load() error {
+    buckets, err := readFile(l.config.File)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    for _, b := range buckets {
+        bk := bucketKey{Hour: b.Hour.Unix(), Key: Key{User: b.User, Port: b.Port, Upstream: b.Upstream}}
+        u := b.Usage
+        l.buckets[bk] = &u
+    }
+    return nil
+}
+
+func readFile(path string) ([]bucket, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var data ledgerFile
+    if err := json.Unmarshal(raw, &data); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    if data.Version != fileVersion {
+        return nil, fmt.Errorf("%s: unsupported version %d", path, data.Version)
+    }
+    return data.Buckets, nil
+}
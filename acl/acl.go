@@ -0,0 +1,179 @@
+package acl
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/netip"
+    "proxy-server/config"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// DeniedError cho biết đích đến bị rule nào từ chối
+type DeniedError struct {
+    Rule   string
+    Reason string
+}
+
+func (e *DeniedError) Error() string {
+    return e.Reason
+}
+
+// lookup phân giải tên miền khi rule có CIDR
+var lookup = net.DefaultResolver.LookupNetIP
+
+// rule là ACLRule đã parse, users = nil áp dụng cho mọi client
+type rule struct {
+    name    string
+    deny    bool
+    users   map[string]bool
+    hosts   []string
+    cidrs   []netip.Prefix
+    ports   []config.PortRange
+    methods []string
+}
+
+// ACL kiểm tra đích đến của client theo các rule trong cấu hình, Configure thay rule khi reload
+type ACL struct {
+    mu    sync.RWMutex
+    rules []rule
+}
+
+// New tạo ACL chưa có rule, mọi đích đến đều được phép
+func New() *ACL {
+    return &ACL{}
+}
+
+// Configure thay các rule, rule đã được kiểm tra trong Config.Check
+func (a *ACL) Configure(rules []config.ACLRule, groups map[string][]string) {
+    compiled := make([]rule, 0, len(rules))
+    for i, r := range rules {
+        c := rule{
+            name:    r.DisplayName(i),
+            deny:    r.Action == config.ACLDeny,
+            methods: r.Methods,
+        }
+        if len(r.Users) > 0 || len(r.Groups) > 0 {
+            c.users = make(map[string]bool)
+            for _, user := range r.Users {
+                c.users[user] = true
+            }
+            for _, group := range r.Groups {
+                for _, user := range groups[group] {
+                    c.users[user] = true
+                }
+            }
+        }
+        for _, host := range r.Hosts {
+            c.hosts = append(c.hosts, normalizeHost(host))
+        }
+        c.cidrs, _ = config.ParsePrefixes(r.CIDRs)
+        c.ports, _ = config.ParsePortRanges(r.Ports)
+        compiled = append(compiled, c)
+    }
+
+    a.mu.Lock()
+    a.rules = compiled
+    a.mu.Unlock()
+}
+
+// Check kiểm tra user (rỗng = client không xác thực) có được dùng method tới host:port không.
+// Rule khớp đầu tiên quyết định, không rule nào khớp thì được phép
+func (a *ACL) Check(ctx context.Context, user, method, host string, port int) error {
+    a.mu.RLock()
+    rules := a.rules
+    a.mu.RUnlock()
+
+    host = normalizeHost(host)
+    var (
+        addrs    []netip.Addr
+        resolved bool
+    )
+    for _, r := range rules {
+        if r.users != nil && !r.users[user] {
+            continue
+        }
+        if len(r.methods) > 0 && !slices.Contains(r.methods, method) {
+            continue
+        }
+        if len(r.ports) > 0 && !slices.ContainsFunc(r.ports, func(p config.PortRange) bool { return p.Contains(port) }) {
+            continue
+        }
+        if len(r.hosts) > 0 && !slices.ContainsFunc(r.hosts, func(pattern string) bool { return matchHost(pattern, host) }) {
+            continue
+        }
+        if len(r.cidrs) > 0 {
+            if !resolved {
+                addrs = resolve(ctx, host)
+                resolved = true
+            }
+            if !r.matchAddrs(addrs) {
+                continue
+            }
+        }
+
+        if r.deny {
+            return &DeniedError{
+                Rule:   r.name,
+                Reason: fmt.Sprintf("destination %s denied by ACL rule %s", net.JoinHostPort(host, strconv.Itoa(port)), r.name),
+            }
+        }
+        return nil
+    }
+    return nil
+}
+
+// matchAddrs so địa chỉ của đích với CIDR. Rule deny khớp khi có một địa chỉ nằm trong CIDR
+// hoặc không phân giải được tên miền, rule allow chỉ khớp khi mọi địa chỉ nằm trong CIDR
+func (r *rule) matchAddrs(addrs []netip.Addr) bool {
+    if len(addrs) == 0 {
+        return r.deny
+    }
+    inside := func(addr netip.Addr) bool {
+        return slices.ContainsFunc(r.cidrs, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+    }
+    if r.deny {
+        return slices.ContainsFunc(addrs, inside)
+    }
+    for _, addr := range addrs {
+        if !inside(addr) {
+            return false
+        }
+    }
+    return true
+}
+
+// resolve trả về địa chỉ của host, host là IP thì trả về chính nó
+func resolve(ctx context.Context, host string) []netip.Addr {
+    if addr, err := netip.ParseAddr(host); err == nil {
+        return []netip.Addr{addr.Unmap()}
+    }
+    addrs, err := lookup(ctx, "ip", host)
+    if err != nil {
+        return nil
+    }
+    for i := range addrs {
+        addrs[i] = addrs[i].Unmap()
+    }
+    return addrs
+}
+
+// matchHost so tên miền với pattern: *, *.example.com (chỉ subdomain) hoặc tên chính xác
+func matchHost(pattern, host string) bool {
+    switch {
+    case pattern == "*":
+        return true
+    case strings.HasPrefix(pattern, "*."):
+        return strings.HasSuffix(host, pattern[1:])
+    }
+    return host == pattern
+}
+
+// normalizeHost bỏ dấu chấm cuối, ngoặc vuông của IPv6 và chuyển về chữ thường
+func normalizeHost(host string) string {
+    host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+    return strings.TrimSuffix(strings.ToLower(host), ".")
+}
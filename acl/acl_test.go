@@ -0,0 +1,120 @@
+package acl
+
+import (
+    "context"
+    "errors"
+    "net/netip"
+    "proxy-server/config"
+    "testing"
+)
+
+// fakeLookup thay DNS bằng bảng tên miền cố định trong lúc chạy test
+func fakeLookup(t *testing.T, hosts map[string][]string) {
+    t.Helper()
+    old := lookup
+    lookup = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+        var addrs []netip.Addr
+        for _, a := range hosts[host] {
+            addrs = append(addrs, netip.MustParseAddr(a))
+        }
+        if len(addrs) == 0 {
+            return nil, errors.New("no such host")
+        }
+        return addrs, nil
+    }
+    t.Cleanup(func() { lookup = old })
+}
+
+func TestCheck(t *testing.T) {
+    fakeLookup(t, map[string][]string{
+        "intranet.example.com": {"10.1.2.3"},
+        "mixed.example.com":    {"93.184.216.34", "10.1.2.3"},
+        "public.example.com":   {"93.184.216.34"},
+        "internal":             {"93.184.216.35"},
+    })
+
+    a := New()
+    a.Configure([]config.ACLRule{
+        {Name: "no-uploads", Users: []string{"carol"}, Action: config.ACLDeny, Methods: []string{"POST", "PUT"}},
+        {Name: "staff-web", Groups: []string{"staff"}, Action: config.ACLAllow, Ports: []string{"80", "443"}},
+        {Name: "staff-default", Groups: []string{"staff"}, Action: config.ACLDeny},
+        {Name: "internal", Action: config.ACLDeny, Hosts: []string{"*.internal"}},
+        {Name: "private", Action: config.ACLDeny, CIDRs: []string{"10.0.0.0/8"}},
+        {Name: "high-ports", Action: config.ACLDeny, Ports: []string{"8000-9000"}},
+    }, map[string][]string{"staff": {"alice", "bob"}})
+
+    tests := []struct {
+        name   string
+        user   string
+        method string
+        host   string
+        port   int
+        rule   string
+    }{
+        {"user rule matches method", "carol", "POST", "example.com", 443, "no-uploads"},
+        {"user rule skips other methods", "carol", "GET", "public.example.com", 443, ""},
+        {"user rule skips other users", "dave", "POST", "public.example.com", 443, ""},
+        {"group allow before group deny", "alice", "GET", "example.com", 443, ""},
+        {"group deny after allowed ports", "bob", "CONNECT", "example.com", 22, "staff-default"},
+        {"wildcard matches subdomain", "", "GET", "db.internal", 80, "internal"},
+        {"wildcard is case insensitive", "", "GET", "DB.Internal.", 80, "internal"},
+        {"wildcard does not match apex", "", "GET", "internal", 80, ""},
+        {"cidr matches ip literal", "", "CONNECT", "10.9.9.9", 443, "private"},
+        {"cidr matches resolved name", "", "GET", "intranet.example.com", 80, "private"},
+        {"deny cidr matches any address", "", "GET", "mixed.example.com", 80, "private"},
+        {"deny cidr matches unresolved name", "", "GET", "unknown.example.com", 80, "private"},
+        {"public name allowed", "", "GET", "public.example.com", 80, ""},
+        {"port range start", "", "GET", "public.example.com", 8000, "high-ports"},
+        {"port range end", "", "GET", "public.example.com", 9000, "high-ports"},
+        {"port after range", "", "GET", "public.example.com", 9001, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := a.Check(context.Background(), tt.user, tt.method, tt.host, tt.port)
+            if tt.rule == "" {
+                if err != nil {
+                    t.Fatalf("Check() = %v, want allowed", err)
+                }
+                return
+            }
+            var denied *DeniedError
+            if !errors.As(err, &denied) {
+                t.Fatalf("Check() = %v, want denied by %s", err, tt.rule)
+            }
+            if denied.Rule != tt.rule {
+                t.Errorf("denied by %s, want %s", denied.Rule, tt.rule)
+            }
+        })
+    }
+}
+
+func TestMatchAddrsAllowNeedsEveryAddress(t *testing.T) {
+    r := rule{cidrs: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+
+    tests := []struct {
+        name  string
+        addrs []string
+        want  bool
+    }{
+        {"all inside", []string{"192.0.2.1", "192.0.2.2"}, true},
+        {"one outside", []string{"192.0.2.1", "198.51.100.1"}, false},
+        {"unresolved", nil, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var addrs []netip.Addr
+            for _, a := range tt.addrs {
+                addrs = append(addrs, netip.MustParseAddr(a))
+            }
+            if got := r.matchAddrs(addrs); got != tt.want {
+                t.Errorf("matchAddrs(%v) = %v, want %v", tt.addrs, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNoRulesAllowsEverything(t *testing.T) {
+    if err := New().Check(context.Background(), "", "CONNECT", "10.0.0.1", 25); err != nil {
+        t.Fatalf("Check() = %v, want allowed", err)
+    }
+}
@@ -0,0 +1,308 @@
+package admin
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "proxy-server/accounting"
+    "proxy-server/config"
+    "proxy-server/server"
+    "proxy-server/upstream"
+    "proxy-server/utils"
+    "strconv"
+
+    "github.com/gorilla/mux"
+    "go.uber.org/zap"
+)
+
+// upstreamStatus là trạng thái và thống kê của upstream, không chứa thông tin đăng nhập
+type upstreamStatus struct {
+    Name        string                `json:"name"`
+    Address     string                `json:"address"`
+    Scheme      string                `json:"scheme"`
+    Pool        string                `json:"pool,omitempty"`
+    Weight      int                   `json:"weight"`
+    Health      upstream.HealthStatus `json:"health"`
+    ActiveConns int64                 `json:"active_conns"`
+    LatencyMs   float64               `json:"latency_ms"`
+}
+
+// addUpstreamRequest là body của POST /api/upstreams, URL nhận cả dạng host:port:user:pass
+type addUpstreamRequest struct {
+    Name   string `json:"name"`
+    URL    string `json:"url"`
+    Pool   string `json:"pool"`
+    Weight int    `json:"weight"`
+}
+
+// credentialsRequest là body (tùy chọn) của POST /api/listeners/{name}/credentials
+type credentialsRequest struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+}
+
+// bandwidthRequest là body của các request PUT .../bandwidth, Rate nhận số byte/giây
+// hoặc chuỗi có đơn vị như "2MB", 0 = không giới hạn
+type bandwidthRequest struct {
+    Rate *config.ByteSize `json:"rate"`
+}
+
+func (s *Server) listListeners(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, s.manager.Listeners())
+}
+
+func (s *Server) enableListener(w http.ResponseWriter, r *http.Request) {
+    s.setListenerEnabled(w, r, true)
+}
+
+func (s *Server) disableListener(w http.ResponseWriter, r *http.Request) {
+    s.setListenerEnabled(w, r, false)
+}
+
+func (s *Server) setListenerEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+    name := mux.Vars(r)["name"]
+    if err := s.manager.SetListenerEnabled(name, enabled); err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin changed listener state",
+        zap.String("listener", name),
+        zap.Bool("enabled", enabled))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) rotateCredentials(w http.ResponseWriter, r *http.Request) {
+    var req credentialsRequest
+    if err := decodeBody(r, &req, true); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    name := mux.Vars(r)["name"]
+    user, err := s.manager.RotateCredentials(name, req.Username, req.Password)
+    if err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin rotated credentials",
+        zap.String("listener", name),
+        zap.String("user", user.Username))
+    writeJSON(w, http.StatusOK, credentialsRequest{Username: user.Username, Password: user.Password})
+}
+
+func (s *Server) listUpstreams(w http.ResponseWriter, r *http.Request) {
+    upstreams := s.manager.Registry().All()
+
+    statuses := make([]upstreamStatus, 0, len(upstreams))
+    for _, u := range upstreams {
+        statuses = append(statuses, upstreamStatus{
+            Name:        u.Name,
+            Address:     u.Address(),
+            Scheme:      u.Config.ProxyScheme,
+            Pool:        u.Config.Pool,
+            Weight:      u.Weight(),
+            Health:      u.HealthStatus(),
+            ActiveConns: u.ActiveConns(),
+            LatencyMs:   float64(u.Latency().Microseconds()) / 1000,
+        })
+    }
+    writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) addUpstream(w http.ResponseWriter, r *http.Request) {
+    var req addUpstreamRequest
+    if err := decodeBody(r, &req, false); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    upstreamCfg, err := config.ParseUpstream(req.URL)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid url: %v", err))
+        return
+    }
+    upstreamCfg.Name = req.Name
+    upstreamCfg.Pool = req.Pool
+    if req.Weight > 0 {
+        upstreamCfg.Weight = req.Weight
+    }
+    if upstreamCfg.Name == "" {
+        upstreamCfg.Name = upstreamCfg.GetProxyAddress()
+    }
+
+    if err := s.manager.AddUpstream(upstreamCfg); err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin added upstream",
+        zap.String("upstream", upstreamCfg.Name),
+        zap.String("proxy_address", upstreamCfg.GetProxyAddress()))
+    writeJSON(w, http.StatusCreated, map[string]string{"name": upstreamCfg.Name})
+}
+
+func (s *Server) removeUpstream(w http.ResponseWriter, r *http.Request) {
+    name := mux.Vars(r)["name"]
+    if err := s.manager.RemoveUpstream(name); err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin removed upstream", zap.String("upstream", name))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listTunnels(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, s.manager.Tunnels().List())
+}
+
+func (s *Server) closeTunnel(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+    if err != nil || !s.manager.Tunnels().Close(id) {
+        writeError(w, http.StatusNotFound, "unknown tunnel")
+        return
+    }
+
+    utils.GetLogger().Info("Admin closed tunnel", zap.Uint64("tunnel_id", id))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// exportUsage xuất lưu lượng trong [from, to), mặc định là 24 giờ gần nhất (theo giờ).
+// Query: from, to (RFC 3339 hoặc YYYY-MM-DD), format (json hoặc csv), by (user,port,upstream)
+func (s *Server) exportUsage(w http.ResponseWriter, r *http.Request) {
+    usage := s.manager.Usage()
+    if usage == nil {
+        writeError(w, http.StatusNotFound, "traffic accounting is disabled")
+        return
+    }
+
+    query := r.URL.Query()
+    from, to, err := accounting.Window(query.Get("from"), query.Get("to"))
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    by, err := accounting.ParseGrouping(query.Get("by"))
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    format := query.Get("format")
+    switch format {
+    case "", "json":
+        format = "json"
+        w.Header().Set("Content-Type", "application/json")
+    case "csv":
+        w.Header().Set("Content-Type", "text/csv")
+        w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+    default:
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (expected csv or json)", format))
+        return
+    }
+
+    accounting.Export(w, format, from, to, usage.Query(from, to, by))
+}
+
+func (s *Server) getBandwidth(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, s.manager.Bandwidth())
+}
+
+func (s *Server) setGlobalBandwidth(w http.ResponseWriter, r *http.Request) {
+    s.setBandwidth(w, r, "global", s.manager.SetGlobalBandwidth)
+}
+
+func (s *Server) setListenerBandwidth(w http.ResponseWriter, r *http.Request) {
+    name := mux.Vars(r)["name"]
+    s.setBandwidth(w, r, "listener "+name, func(rate config.ByteSize) error {
+        return s.manager.SetListenerBandwidth(name, rate)
+    })
+}
+
+func (s *Server) setUserBandwidth(w http.ResponseWriter, r *http.Request) {
+    name := mux.Vars(r)["name"]
+    s.setBandwidth(w, r, "user "+name, func(rate config.ByteSize) error {
+        return s.manager.SetUserBandwidth(name, rate)
+    })
+}
+
+// setBandwidth đọc giới hạn từ body rồi áp dụng bằng set
+func (s *Server) setBandwidth(w http.ResponseWriter, r *http.Request, target string, set func(config.ByteSize) error) {
+    var req bandwidthRequest
+    if err := decodeBody(r, &req, false); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if req.Rate == nil {
+        writeError(w, http.StatusBadRequest, "rate is required")
+        return
+    }
+    if err := set(*req.Rate); err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin changed bandwidth limit",
+        zap.String("target", target),
+        zap.Int64("bytes_per_second", int64(*req.Rate)))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listBans(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, s.manager.Bans())
+}
+
+func (s *Server) removeBan(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    if err := s.manager.Unban(vars["kind"], vars["key"]); err != nil {
+        writeManagerError(w, err)
+        return
+    }
+
+    utils.GetLogger().Info("Admin removed ban", zap.String("kind", vars["kind"]), zap.String("key", vars["key"]))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeBody đọc body JSON vào v, optional cho phép body rỗng
+func decodeBody(r *http.Request, v interface{}, optional bool) error {
+    decoder := json.NewDecoder(r.Body)
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(v); err != nil {
+        if optional && errors.Is(err, io.EOF) {
+            return nil
+        }
+        return fmt.Errorf("invalid request body: %v", err)
+    }
+    return nil
+}
+
+// writeManagerError chuyển lỗi của server.Manager thành HTTP status
+func writeManagerError(w http.ResponseWriter, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, server.ErrInvalidConfig):
+        status = http.StatusBadRequest
+    case errors.Is(err, server.ErrUnknownListener),
+        errors.Is(err, server.ErrUnknownUser),
+        errors.Is(err, server.ErrUnknownBan),
+        errors.Is(err, upstream.ErrUnknownUpstream):
+        status = http.StatusNotFound
+    case errors.Is(err, server.ErrUpstreamExists):
+        status = http.StatusConflict
+    }
+    writeError(w, status, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+    writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
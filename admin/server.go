@@ -0,0 +1,98 @@
+package admin
+
+import (
+    "context"
+    "crypto/subtle"
+    "net/http"
+    "proxy-server/config"
+    "proxy-server/server"
+    "proxy-server/utils"
+
+    "github.com/gorilla/mux"
+    "go.uber.org/zap"
+)
+
+// Server là admin REST API, chạy trên listener riêng và dùng tài khoản riêng
+type Server struct {
+    config  config.AdminConfig
+    manager *server.Manager
+    http    *http.Server
+}
+
+// NewServer tạo admin API cho manager, gọi ListenAndServe để bắt đầu.
+// timeouts giống listener proxy để client chậm không giữ được kết nối tới admin API
+func NewServer(cfg config.AdminConfig, timeouts config.TimeoutConfig, manager *server.Manager) *Server {
+    s := &Server{
+        config:  cfg,
+        manager: manager,
+    }
+    s.http = &http.Server{
+        Addr:              cfg.Listen,
+        Handler:           s.router(),
+        ReadHeaderTimeout: timeouts.Read,
+        ReadTimeout:       timeouts.Read,
+        WriteTimeout:      timeouts.Write,
+        IdleTimeout:       timeouts.Idle,
+    }
+    return s
+}
+
+// ListenAndServe lắng nghe trên địa chỉ cấu hình cho tới khi Shutdown
+func (s *Server) ListenAndServe() error {
+    utils.GetLogger().Info("Starting admin API", zap.String("server_address", s.config.Listen))
+    return s.http.ListenAndServe()
+}
+
+// Shutdown dừng admin API
+func (s *Server) Shutdown(ctx context.Context) error {
+    return s.http.Shutdown(ctx)
+}
+
+func (s *Server) router() http.Handler {
+    r := mux.NewRouter()
+
+    api := r.PathPrefix("/api").Subrouter()
+    api.Use(s.authenticate)
+
+    api.HandleFunc("/listeners", s.listListeners).Methods(http.MethodGet)
+    api.HandleFunc("/listeners/{name}/enable", s.enableListener).Methods(http.MethodPost)
+    api.HandleFunc("/listeners/{name}/disable", s.disableListener).Methods(http.MethodPost)
+    api.HandleFunc("/listeners/{name}/credentials", s.rotateCredentials).Methods(http.MethodPost)
+    api.HandleFunc("/listeners/{name}/bandwidth", s.setListenerBandwidth).Methods(http.MethodPut)
+
+    api.HandleFunc("/upstreams", s.listUpstreams).Methods(http.MethodGet)
+    api.HandleFunc("/upstreams", s.addUpstream).Methods(http.MethodPost)
+    api.HandleFunc("/upstreams/{name}", s.removeUpstream).Methods(http.MethodDelete)
+
+    api.HandleFunc("/tunnels", s.listTunnels).Methods(http.MethodGet)
+    api.HandleFunc("/tunnels/{id:[0-9]+}", s.closeTunnel).Methods(http.MethodDelete)
+
+    api.HandleFunc("/usage", s.exportUsage).Methods(http.MethodGet)
+
+    api.HandleFunc("/bandwidth", s.getBandwidth).Methods(http.MethodGet)
+    api.HandleFunc("/bandwidth", s.setGlobalBandwidth).Methods(http.MethodPut)
+    api.HandleFunc("/users/{name}/bandwidth", s.setUserBandwidth).Methods(http.MethodPut)
+
+    api.HandleFunc("/bans", s.listBans).Methods(http.MethodGet)
+    api.HandleFunc("/bans/{kind}/{key}", s.removeBan).Methods(http.MethodDelete)
+
+    return r
+}
+
+// authenticate kiểm tra Basic auth của admin, so sánh constant-time để không lộ thông tin qua thời gian
+func (s *Server) authenticate(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        username, password, ok := r.BasicAuth()
+        userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.config.Username)) == 1
+        passOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.config.Password)) == 1
+        if !ok || !userOK || !passOK {
+            utils.GetLogger().Warn("Admin authentication failed",
+                zap.String("provided_user", username),
+                zap.String("remote_addr", r.RemoteAddr))
+            w.Header().Set("WWW-Authenticate", `Basic realm="Proxy Admin"`)
+            writeError(w, http.StatusUnauthorized, "authentication required")
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/netip"
+	"proxy-server/config"
+)
+
+// Access là kết quả kiểm tra IP nguồn theo quy tắc allow/deny của listener
+type Access int
+
+const (
+	// AccessAuth: client phải xác thực bằng username/password
+	AccessAuth Access = iota
+	// AccessGranted: client được dùng listener mà không cần mật khẩu
+	AccessGranted
+	// AccessDenied: client bị từ chối, kể cả khi có mật khẩu đúng
+	AccessDenied
+)
+
+// accessRules là danh sách allow/deny đã parse của listener
+type accessRules struct {
+	mode  string
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// newAccessRules parse quy tắc đã được kiểm tra trong Config.Check
+func newAccessRules(cfg config.AccessConfig) accessRules {
+	allow, _ := config.ParsePrefixes(cfg.Allow)
+	deny, _ := config.ParsePrefixes(cfg.Deny)
+	return accessRules{mode: cfg.Mode, allow: allow, deny: deny}
+}
+
+// CheckAddress quyết định theo IP nguồn: từ chối, cho qua hay yêu cầu xác thực.
+// Listener không yêu cầu auth cho qua mọi IP không bị chặn
+func (a *ProxyAuthenticator) CheckAddress(ip string) Access {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Không có IP (ví dụ unix socket) thì chỉ dựa vào mật khẩu
+		if a.rules.mode == config.AccessIP || a.rules.mode == config.AccessBoth {
+			return AccessDenied
+		}
+		return a.authAccess()
+	}
+	addr = addr.Unmap()
+
+	if contains(a.rules.deny, addr) {
+		a.recordFailure("denied")
+		return AccessDenied
+	}
+	allowed := contains(a.rules.allow, addr)
+	switch a.rules.mode {
+	case config.AccessIP:
+		if allowed {
+			return AccessGranted
+		}
+		a.recordFailure("denied")
+		return AccessDenied
+	case config.AccessEither:
+		if allowed {
+			return AccessGranted
+		}
+	case config.AccessBoth:
+		if !allowed {
+			a.recordFailure("denied")
+			return AccessDenied
+		}
+	}
+	return a.authAccess()
+}
+
+// authAccess là kết quả khi IP không quyết định được, phụ thuộc RequireAuth
+func (a *ProxyAuthenticator) authAccess() Access {
+	if !a.config.RequireAuth {
+		return AccessGranted
+	}
+	return AccessAuth
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"net/http"
 	"proxy-server/config"
+	"proxy-server/metrics"
 	"proxy-server/utils"
 	"strings"
 
@@ -13,35 +14,42 @@ import (
 // ProxyAuthenticator xử lý authentication cho proxy
 type ProxyAuthenticator struct {
 	config *config.ProxyConfig
+	rules  accessRules
+	// dummy được kiểm tra thay cho username không tồn tại
+	dummy  config.UserConfig
 }
 
 // NewProxyAuthenticator tạo authenticator mới
 func NewProxyAuthenticator(cfg *config.ProxyConfig) *ProxyAuthenticator {
 	return &ProxyAuthenticator{
 		config: cfg,
+		rules:  newAccessRules(cfg.Access),
+		dummy:  dummyUser(cfg.Users),
 	}
 }
 
-// Authenticate kiểm tra xác thực từ client
-func (a *ProxyAuthenticator) Authenticate(r *http.Request) bool {
+// Authenticate kiểm tra xác thực từ client, trả về username đã xác thực
+func (a *ProxyAuthenticator) Authenticate(r *http.Request) (string, bool) {
 	logger := utils.GetLogger()
 	
 	// Nếu không require auth, cho phép tất cả
 	if !a.config.RequireAuth {
-		return true
+		return "", true
 	}
 
 	// Lấy Proxy-Authorization header
 	authHeader := r.Header.Get("Proxy-Authorization")
 	if authHeader == "" {
 		logger.Debug("No Proxy-Authorization header found")
-		return false
+		a.recordFailure("missing")
+		return "", false
 	}
 
 	// Parse Basic authentication
 	if !strings.HasPrefix(authHeader, "Basic ") {
-		logger.Debug("Invalid auth header format", zap.String("header", authHeader))
-		return false
+		logger.Debug("Invalid auth header format", zap.String("header", utils.RedactHeader(authHeader)))
+		a.recordFailure("malformed")
+		return "", false
 	}
 
 	// Decode base64 credentials
@@ -49,21 +57,33 @@ func (a *ProxyAuthenticator) Authenticate(r *http.Request) bool {
 	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		logger.Debug("Failed to decode auth header", zap.Error(err))
-		return false
+		a.recordFailure("malformed")
+		return "", false
 	}
 
 	// Parse username:password
 	credentials := string(decoded)
 	parts := strings.SplitN(credentials, ":", 2)
 	if len(parts) != 2 {
-		logger.Debug("Invalid credentials format", zap.String("credentials", credentials))
-		return false
+		logger.Debug("Invalid credentials format", zap.String("credentials", utils.RedactCredentials(credentials)))
+		a.recordFailure("malformed")
+		return "", false
 	}
 
-	username, password := parts[0], parts[1]
+	return parts[0], a.Check(parts[0], parts[1])
+}
 
-	// Kiểm tra credentials
-	if username == a.config.AuthUser && password == a.config.AuthPass {
+// Check kiểm tra username/password, dùng chung cho HTTP và SOCKS5 listener
+func (a *ProxyAuthenticator) Check(username, password string) bool {
+	logger := utils.GetLogger()
+
+	// Username không tồn tại vẫn tốn một lần kiểm tra hash như tài khoản có thật,
+	// để thời gian trả lời không cho biết tài khoản nào tồn tại
+	user, ok := a.lookupUser(username)
+	if !ok {
+		user = a.dummy
+	}
+	if VerifyPassword(user, password) && ok {
         logger.Info("Authentication successful", 
             zap.String("user", username),
             zap.Int("proxy_port", a.config.ServerPort))
@@ -72,11 +92,54 @@ func (a *ProxyAuthenticator) Authenticate(r *http.Request) bool {
 
     logger.Warn("Authentication failed", 
         zap.String("provided_user", username),
-        zap.String("expected_user", a.config.AuthUser),
         zap.Int("proxy_port", a.config.ServerPort))
+	a.recordFailure("invalid")
 	return false
 }
 
+// recordFailure ghi nhận lần xác thực thất bại vào metric
+func (a *ProxyAuthenticator) recordFailure(reason string) {
+	metrics.AuthFailures.WithLabelValues(metrics.Port(a.config.ServerPort), reason).Inc()
+}
+
+// lookupUser tìm tài khoản của listener theo username, gateway và pool bỏ phần selector sau dấu '-'
+func (a *ProxyAuthenticator) lookupUser(username string) (config.UserConfig, bool) {
+	base, _ := a.split(username)
+	for _, user := range a.config.Users {
+		if user.Username == base {
+			return user, true
+		}
+	}
+	return config.UserConfig{}, false
+}
+
+// Exists cho biết username (bỏ phần selector) có phải tài khoản của listener không
+func (a *ProxyAuthenticator) Exists(username string) bool {
+	_, ok := a.lookupUser(username)
+	return ok
+}
+
+// Account trả về tài khoản của username (bỏ phần selector), dùng để thống kê theo tài khoản
+func (a *ProxyAuthenticator) Account(username string) string {
+	base, _ := a.split(username)
+	return base
+}
+
+// Selector trả về phần chọn upstream trong username, ví dụ "pool-us" từ "user-pool-us"
+func (a *ProxyAuthenticator) Selector(username string) string {
+	_, selector := a.split(username)
+	return selector
+}
+
+// split tách username thành tài khoản và selector, chỉ áp dụng cho gateway và pool
+func (a *ProxyAuthenticator) split(username string) (string, string) {
+	if a.config.Mode != config.ModeGateway && a.config.Mode != config.ModePool {
+		return username, ""
+	}
+	base, selector, _ := strings.Cut(username, "-")
+	return base, selector
+}
+
 // RequireAuth trả về 407 Proxy Authentication Required
 func (a *ProxyAuthenticator) RequireAuth(w http.ResponseWriter) {
 	logger := utils.GetLogger()
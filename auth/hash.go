@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"proxy-server/config"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verified lưu sha256 của mật khẩu đã kiểm tra đúng theo từng hash, để mỗi request
+// không phải tính lại bcrypt/argon2. Đổi mật khẩu thì hash đổi nên cache cũ không còn dùng
+var verified sync.Map
+
+// VerifyPassword kiểm tra mật khẩu của tài khoản, so sánh constant-time với cả mật khẩu
+// dạng rõ và hash bcrypt/argon2id
+func VerifyPassword(user config.UserConfig, password string) bool {
+	if user.PasswordHash == "" {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	if cached, ok := verified.Load(user.PasswordHash); ok {
+		if subtle.ConstantTimeCompare(sum[:], cached.([]byte)) == 1 {
+			return true
+		}
+	}
+
+	if !verifyHash(user.PasswordHash, password) {
+		return false
+	}
+	verified.Store(user.PasswordHash, sum[:])
+	return true
+}
+
+func verifyHash(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// dummyHashes lưu hash bcrypt giả theo cost, mỗi cost chỉ tạo một lần
+var dummyHashes sync.Map
+
+// dummyUser trả về tài khoản giả có hash cùng loại và cùng chi phí với tài khoản đầu tiên dùng hash.
+// Username không tồn tại được kiểm tra với tài khoản này để thời gian trả lời không lộ tài khoản có thật
+func dummyUser(users []config.UserConfig) config.UserConfig {
+	for _, user := range users {
+		if user.PasswordHash == "" {
+			continue
+		}
+		if h, err := config.ParseArgon2id(user.PasswordHash); err == nil {
+			return config.UserConfig{PasswordHash: fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+				argon2.Version, h.Memory, h.Time, h.Threads,
+				base64.RawStdEncoding.EncodeToString(make([]byte, len(h.Salt))),
+				base64.RawStdEncoding.EncodeToString(make([]byte, len(h.Key))))}
+		}
+		if cost, err := bcrypt.Cost([]byte(user.PasswordHash)); err == nil {
+			if hash, ok := dummyHashes.Load(cost); ok {
+				return config.UserConfig{PasswordHash: hash.(string)}
+			}
+			secret, _ := config.GeneratePassword()
+			hash, err := bcrypt.GenerateFromPassword([]byte(secret), cost)
+			if err == nil {
+				dummyHashes.Store(cost, string(hash))
+				return config.UserConfig{PasswordHash: string(hash)}
+			}
+		}
+	}
+	secret, _ := config.GeneratePassword()
+	return config.UserConfig{Password: secret}
+}
+
+// verifyArgon2id kiểm tra hash argon2id, hash hỏng (tham số làm argon2 panic) trả về false
+func verifyArgon2id(hash, password string) bool {
+	h, err := config.ParseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	derived := argon2.IDKey([]byte(password), h.Salt, h.Time, h.Memory, h.Threads, uint32(len(h.Key)))
+	return subtle.ConstantTimeCompare(derived, h.Key) == 1
+}
+
+// HashPassword tạo hash bcrypt của mật khẩu để lưu vào user store
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"proxy-server/config"
+	"proxy-server/utils"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	utils.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// argon2idHash tạo hash argon2id nhỏ cho test
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestVerifyPassword(t *testing.T) {
+	valid := argon2idHash("secret")
+	salt, key := strings.Split(valid, "$")[4], strings.Split(valid, "$")[5]
+	malformed := func(params string) string {
+		return fmt.Sprintf("$argon2id$v=19$%s$%s$%s", params, salt, key)
+	}
+
+	tests := []struct {
+		name     string
+		user     config.UserConfig
+		password string
+		want     bool
+	}{
+		{"plain", config.UserConfig{Password: "secret"}, "secret", true},
+		{"plain wrong", config.UserConfig{Password: "secret"}, "Secret", false},
+		{"plain empty", config.UserConfig{Password: "secret"}, "", false},
+		{"bcrypt", config.UserConfig{PasswordHash: bcryptHash(t, "secret")}, "secret", true},
+		{"bcrypt wrong", config.UserConfig{PasswordHash: bcryptHash(t, "secret")}, "guess", false},
+		{"argon2id", config.UserConfig{PasswordHash: valid}, "secret", true},
+		{"argon2id wrong", config.UserConfig{PasswordHash: valid}, "guess", false},
+		// Tham số làm argon2.IDKey panic phải bị coi là hash hỏng
+		{"argon2id t=0", config.UserConfig{PasswordHash: malformed("m=64,t=0,p=1")}, "secret", false},
+		{"argon2id p=0", config.UserConfig{PasswordHash: malformed("m=64,t=1,p=0")}, "secret", false},
+		{"argon2id huge m", config.UserConfig{PasswordHash: malformed("m=4294967295,t=1,p=1")}, "secret", false},
+		{"argon2id p overflow", config.UserConfig{PasswordHash: malformed("m=64,t=1,p=256")}, "secret", false},
+		{"argon2id missing key", config.UserConfig{PasswordHash: "$argon2id$v=19$m=64,t=1,p=1$" + salt}, "secret", false},
+		{"argon2id wrong version", config.UserConfig{PasswordHash: strings.Replace(valid, "v=19", "v=16", 1)}, "secret", false},
+		{"argon2id bad salt", config.UserConfig{PasswordHash: fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$!!$%s", key)}, "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPassword(tt.user, tt.password); got != tt.want {
+				t.Errorf("VerifyPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDummyUserMatchesHashCost(t *testing.T) {
+	bcryptUser := config.UserConfig{Username: "alice", PasswordHash: bcryptHash(t, "secret")}
+	argonUser := config.UserConfig{Username: "bob", PasswordHash: argon2idHash("secret")}
+	plainUser := config.UserConfig{Username: "carol", Password: "secret"}
+
+	t.Run("bcrypt", func(t *testing.T) {
+		dummy := dummyUser([]config.UserConfig{plainUser, bcryptUser})
+		cost, err := bcrypt.Cost([]byte(dummy.PasswordHash))
+		if err != nil || cost != bcrypt.MinCost {
+			t.Fatalf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.MinCost)
+		}
+	})
+	t.Run("argon2id", func(t *testing.T) {
+		dummy := dummyUser([]config.UserConfig{argonUser})
+		h, err := config.ParseArgon2id(dummy.PasswordHash)
+		if err != nil || h.Memory != 64 || h.Time != 1 || h.Threads != 1 || len(h.Key) != 32 {
+			t.Fatalf("dummy hash = %q, %v, want m=64,t=1,p=1 with a 32 byte key", dummy.PasswordHash, err)
+		}
+		if VerifyPassword(dummy, "secret") {
+			t.Error("dummy hash accepted the real password")
+		}
+	})
+	t.Run("plain", func(t *testing.T) {
+		dummy := dummyUser([]config.UserConfig{plainUser})
+		if dummy.Password == "" || VerifyPassword(dummy, "") {
+			t.Errorf("dummy plain user accepts an empty password")
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	a := NewProxyAuthenticator(&config.ProxyConfig{
+		Mode:        config.ModeGateway,
+		RequireAuth: true,
+		Users: []config.UserConfig{
+			{Username: "alice", PasswordHash: bcryptHash(t, "secret")},
+			{Username: "bob", Password: "hunter2"},
+		},
+	})
+
+	tests := []struct {
+		username string
+		password string
+		want     bool
+	}{
+		{"alice", "secret", true},
+		{"alice-pool-us", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "hunter2", true},
+		{"mallory", "secret", false},
+		{"mallory", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := a.Check(tt.username, tt.password); got != tt.want {
+			t.Errorf("Check(%q, %q) = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+}
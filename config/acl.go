@@ -0,0 +1,99 @@
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Hành động của ACL rule
+const (
+    ACLAllow = "allow"
+    ACLDeny  = "deny"
+)
+
+// ACLRule là một quy tắc đích đến của client. Rule áp dụng cho Users và thành viên của
+// Groups, cả hai rỗng thì áp dụng cho mọi client. Rule khớp khi đích đến khớp mọi tiêu chí
+// được khai báo (tiêu chí rỗng khớp tất cả), rule khớp đầu tiên quyết định
+type ACLRule struct {
+    Name    string   `yaml:"name"`
+    Action  string   `yaml:"action"`
+    Users   []string `yaml:"users"`
+    Groups  []string `yaml:"groups"`
+    // Hosts là tên miền, *.example.com khớp mọi subdomain, * khớp tất cả
+    Hosts   []string `yaml:"hosts"`
+    // CIDRs khớp đích là địa chỉ IP hoặc tên miền phân giải ra địa chỉ trong CIDR
+    CIDRs   []string `yaml:"cidrs"`
+    // Ports là port hoặc khoảng port như 8000-9000
+    Ports   []string `yaml:"ports"`
+    // Methods là HTTP method, CONNECT áp dụng cho cả tunnel SOCKS5
+    Methods []string `yaml:"methods"`
+}
+
+// PortRange là khoảng port [From, To]
+type PortRange struct {
+    From int
+    To   int
+}
+
+// Contains cho biết port có nằm trong khoảng không
+func (r PortRange) Contains(port int) bool {
+    return port >= r.From && port <= r.To
+}
+
+// ParsePortRanges đọc danh sách port và khoảng port dạng 443 hoặc 8000-9000
+func ParsePortRanges(values []string) ([]PortRange, error) {
+    ranges := make([]PortRange, 0, len(values))
+    for _, v := range values {
+        v = strings.TrimSpace(v)
+        first, last, isRange := strings.Cut(v, "-")
+        from, err := strconv.Atoi(first)
+        to := from
+        if err == nil && isRange {
+            to, err = strconv.Atoi(last)
+        }
+        if err != nil || from < 1 || to > 65535 || from > to {
+            return nil, fmt.Errorf("invalid port or range %q", v)
+        }
+        ranges = append(ranges, PortRange{From: from, To: to})
+    }
+    return ranges, nil
+}
+
+// DisplayName là tên của rule trong log, rule không có tên được gọi theo vị trí
+func (r *ACLRule) DisplayName(index int) string {
+    if r.Name != "" {
+        return r.Name
+    }
+    return fmt.Sprintf("#%d", index+1)
+}
+
+// check kiểm tra rule, groups là các nhóm đã khai báo
+func (r *ACLRule) check(groups map[string][]string) error {
+    if r.Action != ACLAllow && r.Action != ACLDeny {
+        return fmt.Errorf("action must be allow or deny, got %q", r.Action)
+    }
+    for _, group := range r.Groups {
+        if _, ok := groups[group]; !ok {
+            return fmt.Errorf("unknown group %q", group)
+        }
+    }
+    for _, host := range r.Hosts {
+        domain := strings.TrimPrefix(host, "*.")
+        if host != "*" && (domain == "" || strings.Contains(domain, "*")) {
+            return fmt.Errorf("invalid host pattern %q (expected example.com, *.example.com or *)", host)
+        }
+    }
+    if _, err := ParsePrefixes(r.CIDRs); err != nil {
+        return err
+    }
+    if _, err := ParsePortRanges(r.Ports); err != nil {
+        return err
+    }
+    for _, method := range r.Methods {
+        if method == "" || strings.ToUpper(method) != method {
+            return fmt.Errorf("invalid method %q (expected an upper-case HTTP method)", method)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestParsePortRanges(t *testing.T) {
+    tests := []struct {
+        in      []string
+        want    []PortRange
+        wantErr bool
+    }{
+        {in: nil, want: []PortRange{}},
+        {in: []string{"443"}, want: []PortRange{{443, 443}}},
+        {in: []string{" 80 ", "8000-9000"}, want: []PortRange{{80, 80}, {8000, 9000}}},
+        {in: []string{"1-65535"}, want: []PortRange{{1, 65535}}},
+        {in: []string{"0"}, wantErr: true},
+        {in: []string{"65536"}, wantErr: true},
+        {in: []string{"9000-8000"}, wantErr: true},
+        {in: []string{"80-"}, wantErr: true},
+        {in: []string{"-80"}, wantErr: true},
+        {in: []string{"http"}, wantErr: true},
+        {in: []string{"443", "bad"}, wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := ParsePortRanges(tt.in)
+        if tt.wantErr {
+            if err == nil {
+                t.Errorf("ParsePortRanges(%q) = %v, want error", tt.in, got)
+            }
+            continue
+        }
+        if err != nil || !slices.Equal(got, tt.want) {
+            t.Errorf("ParsePortRanges(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+        }
+    }
+}
+
+func TestPortRangeContains(t *testing.T) {
+    r := PortRange{From: 8000, To: 9000}
+    for port, want := range map[int]bool{7999: false, 8000: true, 8500: true, 9000: true, 9001: false} {
+        if got := r.Contains(port); got != want {
+            t.Errorf("Contains(%d) = %v, want %v", port, got, want)
+        }
+    }
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+    "fmt"
+    "net/netip"
+    "strings"
+)
+
+// ParsePrefixes đọc danh sách CIDR IPv4/IPv6, IP đơn lẻ được coi là /32 hoặc /128
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+    prefixes := make([]netip.Prefix, 0, len(values))
+    for _, v := range values {
+        v = strings.TrimSpace(v)
+        if !strings.Contains(v, "/") {
+            addr, err := netip.ParseAddr(v)
+            if err != nil {
+                return nil, fmt.Errorf("invalid IP or CIDR %q", v)
+            }
+            addr = addr.Unmap()
+            prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+            continue
+        }
+
+        prefix, err := netip.ParsePrefix(v)
+        if err != nil {
+            return nil, fmt.Errorf("invalid IP or CIDR %q", v)
+        }
+        prefixes = append(prefixes, prefix.Masked())
+    }
+    return prefixes, nil
+}
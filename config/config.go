@@ -1,116 +1,570 @@
 package config
 
 import (
-    "bufio"
+    "errors"
     "fmt"
-    "net/url"
-    "os"
+    "net"
     "strconv"
     "strings"
+    "time"
 )
 
-type ProxyConfig struct {
-    ServerHost   string
-    ServerPort   int
+// UpstreamConfig mô tả một upstream proxy
+type UpstreamConfig struct {
+    // Tên định danh của upstream, mặc định là host:port
+    Name         string
+    // Scheme của upstream: http, socks5 hoặc socks5h (resolve DNS ở phía proxy)
+    ProxyScheme  string
     ProxyURL     string
     ProxyHost    string
     ProxyPort    int
     ProxyUser    string
     ProxyPass    string
-    // Authentication cho client kết nối đến proxy server này
-    AuthUser     string
-    AuthPass     string
+    // Pool chứa upstream này, khai báo bằng section [name] trong list_proxy.txt
+    // hoặc trường pool trong file cấu hình
+    Pool         string
+    // Trọng số cho strategy weighted, khai báo bằng ?weight=N ở entry dạng URL
+    Weight       int
+}
+
+// Các chế độ hoạt động của listener
+const (
+    // ModeSingle: listener gắn cố định với một upstream (mặc định)
+    ModeSingle  = ""
+    // ModeGateway: username của client chọn upstream, ví dụ user-3002, user-pool-us
+    ModeGateway = "gateway"
+    // ModePool: listener phân phối request qua các upstream trong pool theo strategy
+    ModePool    = "pool"
+)
+
+// Cách kết hợp allow/deny theo IP nguồn với xác thực username/password
+const (
+    // AccessAuth: chỉ xác thực bằng mật khẩu (mặc định)
+    AccessAuth   = "auth"
+    // AccessIP: IP trong allow được dùng không cần mật khẩu, IP khác bị từ chối
+    AccessIP     = "ip"
+    // AccessEither: IP trong allow không cần mật khẩu, IP khác phải xác thực
+    AccessEither = "either"
+    // AccessBoth: chỉ IP trong allow được dùng và vẫn phải xác thực
+    AccessBoth   = "both"
+)
+
+// AccessConfig là quy tắc IP nguồn của listener. IP trong Deny luôn bị từ chối,
+// Allow chỉ dùng với mode ip, either và both
+type AccessConfig struct {
+    Mode  string   `yaml:"mode"`
+    // Allow và Deny là danh sách IP hoặc CIDR, IPv4 hoặc IPv6
+    Allow []string `yaml:"allow"`
+    Deny  []string `yaml:"deny"`
+}
+
+// UserConfig là tài khoản client dùng để xác thực với listener
+type UserConfig struct {
+    Username string      `yaml:"username"`
+    Password string      `yaml:"password"`
+    // PasswordHash là hash bcrypt hoặc argon2id, được dùng thay Password nếu có
+    PasswordHash string  `yaml:"password_hash"`
+    Quota    QuotaLimits `yaml:"quota"`
+    // Bandwidth giới hạn băng thông mỗi chiều (byte/giây) của tài khoản, 0 = không giới hạn
+    Bandwidth ByteSize   `yaml:"bandwidth"`
+    // ConnectPorts thay danh sách port CONNECT của listener cho tài khoản này, rỗng = theo listener
+    ConnectPorts []string `yaml:"connect_ports"`
+}
+
+// QuotaLimits là giới hạn sử dụng của một tài khoản, 0 = không giới hạn
+type QuotaLimits struct {
+    // DailyBytes và MonthlyBytes giới hạn tổng lưu lượng hai chiều theo ngày/tháng (UTC)
+    DailyBytes        ByteSize `yaml:"daily_bytes"`
+    MonthlyBytes      ByteSize `yaml:"monthly_bytes"`
+    RequestsPerMinute int      `yaml:"requests_per_minute"`
+    // MaxTunnels giới hạn số tunnel CONNECT/SOCKS5 mở cùng lúc
+    MaxTunnels        int      `yaml:"max_tunnels"`
+}
+
+// Enabled cho biết tài khoản có giới hạn nào không
+func (q QuotaLimits) Enabled() bool {
+    return q != QuotaLimits{}
+}
+
+// PoolConfig khai báo strategy riêng cho một pool
+type PoolConfig struct {
+    Name     string `yaml:"name"`
+    Strategy string `yaml:"strategy"`
+}
+
+type ProxyConfig struct {
+    // Tên định danh của listener, per-port listener có tên port-<port>
+    Name         string
+    ServerHost   string
+    ServerPort   int
+    // Port của SOCKS5 listener dùng chung upstream với HTTP listener (0 = tắt)
+    SocksPort    int
+    Mode         string
+    // Tên upstream của listener ModeSingle, rỗng với gateway và pool
+    Upstream     string
+    // Pool mà listener ModePool phân phối request, rỗng = tất cả upstream
+    PoolName     string
+    // Strategy chọn upstream của listener ModePool, rỗng = strategy của pool
+    Strategy     string
+    // Header chứa session token cho sticky routing (gateway và pool)
+    SessionHeader string
+    // Tài khoản client được phép dùng listener này
+    Users        []UserConfig
     RequireAuth  bool
+    // Access là quy tắc IP nguồn, Mode rỗng được coi là AccessAuth
+    Access       AccessConfig
+    // Bandwidth giới hạn băng thông mỗi chiều (byte/giây) của listener, 0 = không giới hạn
+    Bandwidth    ByteSize
+    // ConnectPorts là port hoặc khoảng port client được CONNECT tới, rỗng = DefaultConnectPorts
+    ConnectPorts []string
+}
+
+// DefaultConnectPorts là port CONNECT được phép khi listener không khai báo ConnectPorts
+var DefaultConnectPorts = []string{"443"}
+
+// AllowedConnectPorts trả về port CONNECT được phép của listener
+func (p *ProxyConfig) AllowedConnectPorts() []string {
+    if len(p.ConnectPorts) == 0 {
+        return DefaultConnectPorts
+    }
+    return p.ConnectPorts
+}
+
+// TimeoutConfig gom các timeout của listener và upstream
+type TimeoutConfig struct {
+    // Dial giới hạn thời gian mở kết nối qua một upstream
+    Dial     time.Duration `yaml:"dial"`
+    Read     time.Duration `yaml:"read"`
+    Write    time.Duration `yaml:"write"`
+    Idle     time.Duration `yaml:"idle"`
+    // Request giới hạn tổng thời gian một request HTTP qua upstream
+    Request  time.Duration `yaml:"request"`
+    // Shutdown là thời gian chờ các kết nối đang chạy khi tắt server
+    Shutdown time.Duration `yaml:"shutdown"`
 }
 
 type Config struct {
-    Proxies []ProxyConfig
+    Upstreams []UpstreamConfig
+    Listeners []ProxyConfig
+    Pools     []PoolConfig
+    // Strategy mặc định khi gateway chọn upstream trong pool
+    Strategy string
+    // SessionTTL là thời gian giữ upstream cho một session kể từ lần dùng cuối
+    SessionTTL time.Duration
+    Timeouts    TimeoutConfig
+    HealthCheck HealthCheckConfig
+    Retry       RetryConfig
+    Breaker     BreakerConfig
+    // Bandwidth giới hạn tổng băng thông mỗi chiều (byte/giây) của mọi listener, 0 = không giới hạn
+    Bandwidth   ByteSize
+    // ListErrors là các dòng không hợp lệ đã bị bỏ qua khi đọc danh sách upstream
+    ListErrors  []error
+    // Files là các file đã đọc để tạo cấu hình (file cấu hình và danh sách upstream)
+    Files       []string
+    // Watch bật reload tự động khi một trong các file thay đổi
+    Watch       bool
+    Admin       AdminConfig
+    Metrics     MetricsConfig
+    Accounting  AccountingConfig
+    Quotas      QuotaConfig
+    Lockout     LockoutConfig
+    DestinationGuard DestinationGuardConfig
+    // Groups là các nhóm tài khoản dùng trong ACL, theo tên nhóm
+    Groups      map[string][]string
+    // ACL là các quy tắc đích đến theo tài khoản, xét theo thứ tự
+    ACL         []ACLRule
 }
 
-func LoadConfig() *Config {
-    // Đọc danh sách proxies từ file
-    proxies, err := loadProxiesFromFile("list_proxy.txt")
-    if err != nil {
-        panic("Failed to load proxies: " + err.Error())
+// LockoutConfig cấu hình chống dò mật khẩu: lần xác thực sai được đếm theo IP nguồn
+// và theo tài khoản, quá MaxFailures lần trong Window thì bị cấm tạm thời
+type LockoutConfig struct {
+    Enabled     bool          `yaml:"enabled"`
+    MaxFailures int           `yaml:"max_failures"`
+    Window      time.Duration `yaml:"window"`
+    // BanDuration là thời gian cấm lần đầu, gấp đôi sau mỗi lần bị cấm lại và không quá MaxBan
+    BanDuration time.Duration `yaml:"ban_duration"`
+    MaxBan      time.Duration `yaml:"max_ban"`
+    // Delay là thời gian trả lời chậm lại cho mỗi lần sai trong Window, không quá MaxDelay
+    Delay       time.Duration `yaml:"delay"`
+    MaxDelay    time.Duration `yaml:"max_delay"`
+    // Allowlist là các IP/CIDR không bị đếm lần sai và không bao giờ bị cấm
+    Allowlist   []string      `yaml:"allowlist"`
+}
+
+// DestinationGuardConfig cấu hình chặn đích đến là địa chỉ nội bộ (private, loopback,
+// link-local, multicast), đích là tên miền được kiểm tra theo địa chỉ đã phân giải
+type DestinationGuardConfig struct {
+    Enabled bool     `yaml:"enabled"`
+    // Allow là các IP/CIDR nội bộ client vẫn được phép kết nối tới
+    Allow   []string `yaml:"allow"`
+}
+
+// QuotaConfig cấu hình nơi lưu lưu lượng đã dùng của quota, chỉ đọc khi khởi động.
+// Giới hạn của từng tài khoản nằm trong UserConfig.Quota
+type QuotaConfig struct {
+    // File lưu lưu lượng trong ngày/tháng, rỗng = chỉ giữ trong bộ nhớ
+    File          string        `yaml:"file"`
+    FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// AccountingConfig cấu hình thống kê lưu lượng theo tài khoản, chỉ đọc khi khởi động
+type AccountingConfig struct {
+    // File lưu lưu lượng theo giờ, rỗng = tắt thống kê
+    File          string        `yaml:"file"`
+    // FlushInterval là chu kỳ ghi lưu lượng xuống file
+    FlushInterval time.Duration `yaml:"flush_interval"`
+    // Retention là thời gian giữ số liệu, 0 = giữ mãi
+    Retention     time.Duration `yaml:"retention"`
+}
+
+// MetricsConfig cấu hình endpoint /metrics cho Prometheus, chỉ đọc khi khởi động
+type MetricsConfig struct {
+    // Listen là địa chỉ của endpoint /metrics, rỗng = tắt
+    Listen string `yaml:"listen"`
+}
+
+// AdminConfig cấu hình admin API, chỉ đọc khi khởi động
+type AdminConfig struct {
+    // Listen là địa chỉ của admin API, rỗng = tắt
+    Listen   string `yaml:"listen"`
+    Username string `yaml:"username"`
+    Password string `yaml:"password"`
+}
+
+// HealthCheckConfig cấu hình kiểm tra định kỳ các upstream
+type HealthCheckConfig struct {
+    // Interval giữa hai lần kiểm tra, 0 = tắt health check
+    Interval  time.Duration `yaml:"interval"`
+    Timeout   time.Duration `yaml:"timeout"`
+    // ConnectTarget là host:port dùng để thử CONNECT qua upstream
+    ConnectTarget string `yaml:"target"`
+    // URL cho HTTP GET qua upstream, rỗng = bỏ qua bước này
+    URL       string `yaml:"url"`
+    // Số lần thành công liên tiếp để upstream unhealthy trở lại healthy
+    HealthyThreshold   int `yaml:"rise"`
+    // Số lần thất bại liên tiếp để upstream healthy bị coi là unhealthy
+    UnhealthyThreshold int `yaml:"fall"`
+}
+
+// RetryConfig cấu hình thử lại trên upstream khác khi kết nối qua upstream bị lỗi
+type RetryConfig struct {
+    // MaxAttempts là tổng số lần thử, tính cả lần đầu (1 = không retry)
+    MaxAttempts int `yaml:"max_attempts"`
+    // Backoff là thời gian chờ trước lần retry đầu tiên, tăng gấp đôi sau mỗi lần
+    Backoff     time.Duration `yaml:"backoff"`
+    MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// BreakerConfig cấu hình circuit breaker cho từng upstream
+type BreakerConfig struct {
+    Enabled bool `yaml:"enabled"`
+    // FailureThreshold là số lỗi liên tiếp để mở breaker, 0 = không dùng điều kiện này
+    FailureThreshold int `yaml:"failure_threshold"`
+    // ErrorRate là tỉ lệ lỗi (0-1) trong WindowSize request gần nhất để mở breaker, 0 = không dùng
+    ErrorRate   float64 `yaml:"error_rate"`
+    WindowSize  int `yaml:"window_size"`
+    // MinRequests là số request tối thiểu trong cửa sổ trước khi xét ErrorRate
+    MinRequests int `yaml:"min_requests"`
+    // Cooldown là thời gian breaker mở trước khi cho phép request thử (half-open)
+    Cooldown    time.Duration `yaml:"cooldown"`
+    // HalfOpenMaxRequests là số request thử đồng thời khi half-open
+    HalfOpenMaxRequests int `yaml:"half_open_requests"`
+}
+
+// LoadConfig đọc cấu hình từ file YAML/JSON tại path rồi áp dụng các biến môi trường.
+// path rỗng giữ cách chạy cũ: đọc list_proxy.txt và mở listener riêng cho từng upstream.
+// Dòng lỗi trong danh sách upstream được bỏ qua và ghi vào ListErrors, trừ khi bật strict
+func LoadConfig(path string) (*Config, error) {
+    return load(path, false, "")
+}
+
+// LoadConfigWithStore đọc cấu hình như LoadConfig, store thay cho user_store và USER_STORE
+func LoadConfigWithStore(path, store string) (*Config, error) {
+    return load(path, false, store)
+}
+
+// Validate đọc cấu hình như LoadConfig nhưng luôn ở chế độ strict,
+// lỗi trả về gom tất cả vấn đề tìm được (mỗi lỗi một dòng)
+func Validate(path string) (*Config, error) {
+    return load(path, true, "")
+}
+
+// load đọc cấu hình, store khác rỗng thay cho user store trong file và biến môi trường
+func load(path string, strict bool, store string) (*Config, error) {
+    fc := defaultFileConfig()
+    if path != "" {
+        // File cấu hình khai báo tường minh nguồn upstream và listener
+        fc.UpstreamFiles = nil
+        fc.PerPort.Enabled = false
+        if err := readFileConfig(path, &fc); err != nil {
+            return nil, err
+        }
     }
-    
-    return &Config{
-        Proxies: proxies,
+
+    if err := applyEnv(&fc); err != nil {
+        return nil, err
+    }
+    if store != "" {
+        fc.UserStore = store
     }
-}
 
-func loadProxiesFromFile(filename string) ([]ProxyConfig, error) {
-    file, err := os.Open(filename)
-    if err != nil {
+    cfg, buildErr := fc.build()
+    if path != "" {
+        cfg.Files = append([]string{path}, cfg.Files...)
+    }
+    if err := applyListenerEnv(cfg, fc.SessionHeader); err != nil {
         return nil, err
     }
-    defer file.Close()
-    
-    var proxies []ProxyConfig
-    scanner := bufio.NewScanner(file)
-    port := 3000 // Bắt đầu từ port 3000
-    
-    for scanner.Scan() {
-        line := strings.TrimSpace(scanner.Text())
-        if line == "" || strings.HasPrefix(line, "#") {
-            continue
-        }
-        
-        parts := strings.Split(line, ":")
-        if len(parts) != 4 {
-            continue // Bỏ qua dòng không đúng format
-        }
-        
-        proxyHost := parts[0]
-        proxyPort, err := strconv.Atoi(parts[1])
-        if err != nil {
-            continue // Bỏ qua nếu port không hợp lệ
-        }
-        
-        proxyUser := parts[2]
-        proxyPass := parts[3]
-        
-        proxyURL := fmt.Sprintf("http://%s:%s@%s:%d", 
-            url.QueryEscape(proxyUser), 
-            url.QueryEscape(proxyPass), 
-            proxyHost, 
-            proxyPort)
-        
-        // Tạo auth credentials cho proxy server này
-        authUser := fmt.Sprintf("user%d", port)
-        authPass := fmt.Sprintf("pass%d", port)
-        
-        proxyConfig := ProxyConfig{
-            ServerHost:  "0.0.0.0",
-            ServerPort:  port,
-            ProxyURL:    proxyURL,
-            ProxyHost:   proxyHost,
-            ProxyPort:   proxyPort,
-            ProxyUser:   proxyUser,
-            ProxyPass:   proxyPass,
-            AuthUser:    authUser,
-            AuthPass:    authPass,
-            RequireAuth: true,
-        }
-
-        fmt.Printf("Cau hinh Port %d: ProxyTo=%s:%d, Auth=%s:%s\n", 
-            port, proxyHost, proxyPort, authUser, authPass)
-        
-        proxies = append(proxies, proxyConfig)
-        port++ // Tăng port cho proxy tiếp theo
-    }
-    
-    if err := scanner.Err(); err != nil {
+
+    err := errors.Join(buildErr, cfg.Check())
+    if strict || fc.Strict {
+        err = errors.Join(append(cfg.ListErrors, err)...)
+    }
+    if err != nil {
         return nil, err
     }
-    
-    return proxies, nil
+    return cfg, nil
+}
+
+// Check kiểm tra tính nhất quán của cấu hình sau khi đã áp dụng file và biến môi trường
+// (hoặc thay đổi từ admin API), trả về tất cả lỗi tìm được thay vì dừng ở lỗi đầu tiên
+func (c *Config) Check() error {
+    var errs []error
+    fail := func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf(format, args...))
+    }
+
+    upstreams := make(map[string]bool)
+    pools := make(map[string]bool)
+    for _, u := range c.Upstreams {
+        if upstreams[u.Name] {
+            fail("duplicate upstream name %q", u.Name)
+        }
+        upstreams[u.Name] = true
+        if u.Pool != "" {
+            pools[u.Pool] = true
+        }
+    }
+    if len(c.Upstreams) == 0 {
+        fail("no upstreams configured")
+    }
+
+    for _, p := range c.Pools {
+        if err := CheckPoolName(p.Name); err != nil {
+            fail("pool %q: %v", p.Name, err)
+        } else if !pools[p.Name] {
+            fail("pool %q has no upstreams", p.Name)
+        }
+    }
+
+    if len(c.Listeners) == 0 {
+        fail("no listeners configured")
+    }
+
+    names := make(map[string]bool)
+    ports := make(map[int]string)
+    // Quota tính theo tài khoản trên mọi listener nên mỗi tài khoản chỉ có một giới hạn
+    type userQuota struct {
+        limits   QuotaLimits
+        listener string
+    }
+    quotas := make(map[string]userQuota)
+    for _, l := range c.Listeners {
+        if names[l.Name] {
+            fail("duplicate listener name %q", l.Name)
+        }
+        names[l.Name] = true
+
+        if l.ServerPort == 0 {
+            fail("listener %s: port is required", l.Name)
+        }
+        for _, port := range []int{l.ServerPort, l.SocksPort} {
+            if port == 0 {
+                continue
+            }
+            if port < 0 || port > 65535 {
+                fail("listener %s: invalid port %d", l.Name, port)
+                continue
+            }
+            if other, ok := ports[port]; ok {
+                fail("listener %s: port %d already used by listener %s", l.Name, port, other)
+            }
+            ports[port] = l.Name
+        }
+
+        switch l.Mode {
+        case ModeSingle:
+            if !upstreams[l.Upstream] {
+                fail("listener %s: unknown upstream %q", l.Name, l.Upstream)
+            }
+        case ModeGateway:
+        case ModePool:
+            if l.PoolName != "" && !pools[l.PoolName] {
+                fail("listener %s: unknown pool %q", l.Name, l.PoolName)
+            }
+        default:
+            fail("listener %s: unknown mode %q", l.Name, l.Mode)
+        }
+
+        if err := l.Access.check(); err != nil {
+            fail("listener %s: %v", l.Name, err)
+        }
+        if _, err := ParsePortRanges(l.ConnectPorts); err != nil {
+            fail("listener %s: connect ports: %v", l.Name, err)
+        }
+        if l.RequireAuth && l.Access.Mode != AccessIP && len(l.Users) == 0 {
+            fail("listener %s: no users configured", l.Name)
+        }
+        for _, u := range l.Users {
+            // Gateway và pool tách selector khỏi username tại dấu '-' đầu tiên
+            if l.Mode != ModeSingle && strings.Contains(u.Username, "-") {
+                fail("listener %s: username %q must not contain '-'", l.Name, u.Username)
+            }
+            q := u.Quota
+            if q.DailyBytes < 0 || q.MonthlyBytes < 0 || q.RequestsPerMinute < 0 || q.MaxTunnels < 0 {
+                fail("listener %s: user %q: quota limits must not be negative", l.Name, u.Username)
+            }
+            if other, ok := quotas[u.Username]; ok && other.limits != q {
+                fail("listener %s: user %q: quota differs from the quota on listener %s", l.Name, u.Username, other.listener)
+            } else if !ok {
+                quotas[u.Username] = userQuota{q, l.Name}
+            }
+            if _, err := ParsePortRanges(u.ConnectPorts); err != nil {
+                fail("listener %s: user %q: connect ports: %v", l.Name, u.Username, err)
+            }
+        }
+    }
+
+    if c.SessionTTL <= 0 {
+        fail("session TTL must be positive")
+    }
+    if c.HealthCheck.HealthyThreshold < 1 || c.HealthCheck.UnhealthyThreshold < 1 {
+        fail("health check thresholds must be at least 1")
+    }
+    if c.Retry.MaxAttempts < 1 {
+        fail("retry max attempts must be at least 1")
+    }
+    if c.Breaker.ErrorRate < 0 || c.Breaker.ErrorRate > 1 {
+        fail("breaker error rate must be between 0 and 1")
+    }
+    if c.Breaker.WindowSize < 1 || c.Breaker.HalfOpenMaxRequests < 1 {
+        fail("breaker window size and half-open requests must be at least 1")
+    }
+    if c.Admin.Listen != "" && c.Admin.Password == "" {
+        fail("admin API requires a password")
+    }
+    if c.Accounting.File != "" && c.Accounting.FlushInterval <= 0 {
+        fail("accounting flush interval must be positive")
+    }
+    if c.Quotas.File != "" && c.Quotas.FlushInterval <= 0 {
+        fail("quota flush interval must be positive")
+    }
+    if c.Lockout.Enabled {
+        if c.Lockout.MaxFailures < 1 || c.Lockout.Window <= 0 || c.Lockout.BanDuration <= 0 {
+            fail("lockout max failures, window and ban duration must be positive")
+        }
+        if c.Lockout.MaxBan < c.Lockout.BanDuration {
+            fail("lockout max ban must not be shorter than the ban duration")
+        }
+        if c.Lockout.Delay < 0 || c.Lockout.MaxDelay < 0 {
+            fail("lockout delays must not be negative")
+        }
+    }
+    if _, err := ParsePrefixes(c.Lockout.Allowlist); err != nil {
+        fail("lockout allowlist: %v", err)
+    }
+    if _, err := ParsePrefixes(c.DestinationGuard.Allow); err != nil {
+        fail("destination guard allow: %v", err)
+    }
+    for i := range c.ACL {
+        if err := c.ACL[i].check(c.Groups); err != nil {
+            fail("acl rule %s: %v", c.ACL[i].DisplayName(i), err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// Clone trả về bản sao của cấu hình, sửa upstream, listener và tài khoản
+// trên bản sao không ảnh hưởng tới bản gốc
+func (c *Config) Clone() *Config {
+    clone := *c
+    clone.Upstreams = append([]UpstreamConfig(nil), c.Upstreams...)
+    clone.Pools = append([]PoolConfig(nil), c.Pools...)
+    clone.Listeners = append([]ProxyConfig(nil), c.Listeners...)
+    for i := range clone.Listeners {
+        clone.Listeners[i].Users = append([]UserConfig(nil), c.Listeners[i].Users...)
+    }
+    return &clone
+}
+
+// QuotaLimits trả về giới hạn quota theo username của các tài khoản có giới hạn.
+// Check bảo đảm một tài khoản có cùng giới hạn trên mọi listener
+func (c *Config) QuotaLimits() map[string]QuotaLimits {
+    limits := make(map[string]QuotaLimits)
+    for _, l := range c.Listeners {
+        for _, u := range l.Users {
+            if u.Quota.Enabled() {
+                limits[u.Username] = u.Quota
+            }
+        }
+    }
+    return limits
+}
+
+// UserBandwidths trả về giới hạn băng thông theo username của các tài khoản có giới hạn
+func (c *Config) UserBandwidths() map[string]ByteSize {
+    rates := make(map[string]ByteSize)
+    for _, l := range c.Listeners {
+        for _, u := range l.Users {
+            if u.Bandwidth > 0 {
+                rates[u.Username] = u.Bandwidth
+            }
+        }
+    }
+    return rates
+}
+
+// Upstream trả về cấu hình upstream theo tên
+func (c *Config) Upstream(name string) (*UpstreamConfig, bool) {
+    for i := range c.Upstreams {
+        if c.Upstreams[i].Name == name {
+            return &c.Upstreams[i], true
+        }
+    }
+    return nil, false
 }
 
 func (c *ProxyConfig) GetServerAddress() string {
-    return fmt.Sprintf("%s:%d", c.ServerHost, c.ServerPort)
+    return net.JoinHostPort(c.ServerHost, strconv.Itoa(c.ServerPort))
+}
+
+func (c *ProxyConfig) GetSocksAddress() string {
+    return net.JoinHostPort(c.ServerHost, strconv.Itoa(c.SocksPort))
 }
 
-func (c *ProxyConfig) GetProxyAddress() string {
-    return fmt.Sprintf("%s:%d", c.ProxyHost, c.ProxyPort)
-}
\ No newline at end of file
+func (c *UpstreamConfig) GetProxyAddress() string {
+    return net.JoinHostPort(c.ProxyHost, strconv.Itoa(c.ProxyPort))
+}
+
+// check kiểm tra mode và danh sách IP của quy tắc truy cập
+func (a *AccessConfig) check() error {
+    switch a.Mode {
+    case "", AccessAuth:
+        if len(a.Allow) > 0 {
+            return fmt.Errorf("access allow list requires mode ip, either or both")
+        }
+    case AccessIP, AccessEither, AccessBoth:
+        if len(a.Allow) == 0 {
+            return fmt.Errorf("access mode %s requires an allow list", a.Mode)
+        }
+    default:
+        return fmt.Errorf("unknown access mode %q (expected auth, ip, either or both)", a.Mode)
+    }
+    if _, err := ParsePrefixes(a.Allow); err != nil {
+        return fmt.Errorf("access allow: %w", err)
+    }
+    if _, err := ParsePrefixes(a.Deny); err != nil {
+        return fmt.Errorf("access deny: %w", err)
+    }
+    return nil
+}
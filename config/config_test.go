@@ -0,0 +1,39 @@
+package config
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestCheckQuotaPerAccount(t *testing.T) {
+    limited := UserConfig{Username: "alice", Password: "secret", Quota: QuotaLimits{DailyBytes: 1 << 30}}
+    other := UserConfig{Username: "alice", Password: "secret", Quota: QuotaLimits{DailyBytes: 2 << 30}}
+    unlimited := UserConfig{Username: "alice", Password: "secret"}
+
+    tests := []struct {
+        name     string
+        a, b     UserConfig
+        conflict bool
+    }{
+        {"same limits", limited, limited, false},
+        {"different limits", limited, other, true},
+        {"limited and unlimited", limited, unlimited, true},
+        {"both unlimited", unlimited, unlimited, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg := &Config{
+                Upstreams: []UpstreamConfig{{Name: "up", ProxyScheme: "http", ProxyHost: "127.0.0.1", ProxyPort: 8080}},
+                Listeners: []ProxyConfig{
+                    {Name: "a", ServerPort: 3000, Upstream: "up", RequireAuth: true, Users: []UserConfig{tt.a}},
+                    {Name: "b", ServerPort: 3001, Upstream: "up", RequireAuth: true, Users: []UserConfig{tt.b}},
+                },
+            }
+            err := cfg.Check()
+            got := err != nil && strings.Contains(err.Error(), `user "alice": quota differs from the quota on listener a`)
+            if got != tt.conflict {
+                t.Errorf("Check() = %v, want quota conflict %v", err, tt.conflict)
+            }
+        })
+    }
+}
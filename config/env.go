@@ -0,0 +1,348 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+func getEnv(key, fallback string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q", key, v)
+    }
+    return n, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback, nil
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q", key, v)
+    }
+    return d, nil
+}
+
+func getEnvByteSize(key string, fallback ByteSize) (ByteSize, error) {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback, nil
+    }
+    size, err := ParseByteSize(v)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q", key, v)
+    }
+    return size, nil
+}
+
+// getEnvBool trả về fallback khi biến môi trường không được đặt, "false" tắt và giá trị khác bật
+func getEnvBool(key string, fallback bool) bool {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback
+    }
+    return v != "false"
+}
+
+// getEnvList đọc danh sách cách nhau bởi dấu phẩy, bỏ qua phần tử rỗng
+func getEnvList(key string, fallback []string) []string {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback
+    }
+    var list []string
+    for _, item := range strings.Split(v, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
+}
+
+// applyEnv ghi đè cấu hình đọc từ file bằng các biến môi trường đã được đặt
+func applyEnv(fc *fileConfig) error {
+    fc.PerPort.Enabled = getEnvBool("PER_PORT_LISTENERS", fc.PerPort.Enabled)
+    fc.Strict = getEnvBool("STRICT_LIST", fc.Strict)
+    fc.Watch = getEnvBool("CONFIG_WATCH", fc.Watch)
+    fc.Strategy = getEnv("UPSTREAM_STRATEGY", fc.Strategy)
+    fc.SessionHeader = getEnv("SESSION_HEADER", fc.SessionHeader)
+    fc.Admin.Listen = getEnv("ADMIN_LISTEN", fc.Admin.Listen)
+    fc.Admin.Username = getEnv("ADMIN_USER", fc.Admin.Username)
+    fc.Admin.Password = getEnv("ADMIN_PASS", fc.Admin.Password)
+    fc.Metrics.Listen = getEnv("METRICS_LISTEN", fc.Metrics.Listen)
+    fc.UserStore = getEnv("USER_STORE", fc.UserStore)
+
+    var err error
+    if fc.SessionTTL, err = getEnvDuration("SESSION_TTL", fc.SessionTTL); err != nil {
+        return err
+    }
+    if fc.Bandwidth, err = getEnvByteSize("BANDWIDTH_LIMIT", fc.Bandwidth); err != nil {
+        return err
+    }
+    if fc.PerPort.Bandwidth, err = getEnvByteSize("PER_PORT_BANDWIDTH", fc.PerPort.Bandwidth); err != nil {
+        return err
+    }
+    applyAccessEnv("PER_PORT", &fc.PerPort.Access)
+    fc.PerPort.ConnectPorts = getEnvList("PER_PORT_CONNECT_PORTS", fc.PerPort.ConnectPorts)
+    if err := applyTimeoutEnv(&fc.Timeouts); err != nil {
+        return err
+    }
+    if err := applyHealthCheckEnv(&fc.HealthCheck); err != nil {
+        return err
+    }
+    if err := applyRetryEnv(&fc.Retry); err != nil {
+        return err
+    }
+    if err := applyAccountingEnv(&fc.Accounting); err != nil {
+        return err
+    }
+    if err := applyQuotaEnv(&fc.Quotas); err != nil {
+        return err
+    }
+    if err := applyLockoutEnv(&fc.Lockout); err != nil {
+        return err
+    }
+    applyDestinationGuardEnv(&fc.DestinationGuard)
+    return applyBreakerEnv(&fc.Breaker)
+}
+
+// applyAccountingEnv đọc các biến môi trường ACCOUNTING_*
+func applyAccountingEnv(cfg *AccountingConfig) error {
+    cfg.File = getEnv("ACCOUNTING_FILE", cfg.File)
+
+    var err error
+    if cfg.FlushInterval, err = getEnvDuration("ACCOUNTING_FLUSH_INTERVAL", cfg.FlushInterval); err != nil {
+        return err
+    }
+    if cfg.Retention, err = getEnvDuration("ACCOUNTING_RETENTION", cfg.Retention); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyQuotaEnv đọc các biến môi trường QUOTA_*
+func applyQuotaEnv(cfg *QuotaConfig) error {
+    cfg.File = getEnv("QUOTA_FILE", cfg.File)
+
+    var err error
+    cfg.FlushInterval, err = getEnvDuration("QUOTA_FLUSH_INTERVAL", cfg.FlushInterval)
+    return err
+}
+
+// applyLockoutEnv đọc các biến môi trường LOCKOUT_*, LOCKOUT_ALLOWLIST cách nhau bởi dấu phẩy
+func applyLockoutEnv(cfg *LockoutConfig) error {
+    cfg.Enabled = getEnvBool("LOCKOUT_ENABLED", cfg.Enabled)
+    cfg.Allowlist = getEnvList("LOCKOUT_ALLOWLIST", cfg.Allowlist)
+
+    var err error
+    if cfg.MaxFailures, err = getEnvInt("LOCKOUT_MAX_FAILURES", cfg.MaxFailures); err != nil {
+        return err
+    }
+    if cfg.Window, err = getEnvDuration("LOCKOUT_WINDOW", cfg.Window); err != nil {
+        return err
+    }
+    if cfg.BanDuration, err = getEnvDuration("LOCKOUT_BAN_DURATION", cfg.BanDuration); err != nil {
+        return err
+    }
+    if cfg.MaxBan, err = getEnvDuration("LOCKOUT_MAX_BAN", cfg.MaxBan); err != nil {
+        return err
+    }
+    if cfg.Delay, err = getEnvDuration("LOCKOUT_DELAY", cfg.Delay); err != nil {
+        return err
+    }
+    if cfg.MaxDelay, err = getEnvDuration("LOCKOUT_MAX_DELAY", cfg.MaxDelay); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyDestinationGuardEnv đọc DESTINATION_GUARD_ENABLED và DESTINATION_GUARD_ALLOW (IP/CIDR cách nhau bởi dấu phẩy)
+func applyDestinationGuardEnv(cfg *DestinationGuardConfig) {
+    cfg.Enabled = getEnvBool("DESTINATION_GUARD_ENABLED", cfg.Enabled)
+    cfg.Allow = getEnvList("DESTINATION_GUARD_ALLOW", cfg.Allow)
+}
+
+// applyAccessEnv đọc <prefix>_ACCESS_MODE, <prefix>_ALLOW và <prefix>_DENY (IP/CIDR cách nhau bởi dấu phẩy)
+func applyAccessEnv(prefix string, cfg *AccessConfig) {
+    cfg.Mode = getEnv(prefix+"_ACCESS_MODE", cfg.Mode)
+    cfg.Allow = getEnvList(prefix+"_ALLOW", cfg.Allow)
+    cfg.Deny = getEnvList(prefix+"_DENY", cfg.Deny)
+}
+
+// applyTimeoutEnv đọc các biến môi trường TIMEOUT_*
+func applyTimeoutEnv(cfg *TimeoutConfig) error {
+    var err error
+    if cfg.Dial, err = getEnvDuration("TIMEOUT_DIAL", cfg.Dial); err != nil {
+        return err
+    }
+    if cfg.Read, err = getEnvDuration("TIMEOUT_READ", cfg.Read); err != nil {
+        return err
+    }
+    if cfg.Write, err = getEnvDuration("TIMEOUT_WRITE", cfg.Write); err != nil {
+        return err
+    }
+    if cfg.Idle, err = getEnvDuration("TIMEOUT_IDLE", cfg.Idle); err != nil {
+        return err
+    }
+    if cfg.Request, err = getEnvDuration("TIMEOUT_REQUEST", cfg.Request); err != nil {
+        return err
+    }
+    if cfg.Shutdown, err = getEnvDuration("TIMEOUT_SHUTDOWN", cfg.Shutdown); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyHealthCheckEnv đọc các biến môi trường HEALTH_CHECK_*
+func applyHealthCheckEnv(cfg *HealthCheckConfig) error {
+    cfg.ConnectTarget = getEnv("HEALTH_CHECK_TARGET", cfg.ConnectTarget)
+    cfg.URL = getEnv("HEALTH_CHECK_URL", cfg.URL)
+
+    var err error
+    if cfg.Interval, err = getEnvDuration("HEALTH_CHECK_INTERVAL", cfg.Interval); err != nil {
+        return err
+    }
+    if cfg.Timeout, err = getEnvDuration("HEALTH_CHECK_TIMEOUT", cfg.Timeout); err != nil {
+        return err
+    }
+    if cfg.HealthyThreshold, err = getEnvInt("HEALTH_CHECK_RISE", cfg.HealthyThreshold); err != nil {
+        return err
+    }
+    if cfg.UnhealthyThreshold, err = getEnvInt("HEALTH_CHECK_FALL", cfg.UnhealthyThreshold); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyRetryEnv đọc các biến môi trường RETRY_*
+func applyRetryEnv(cfg *RetryConfig) error {
+    var err error
+    if cfg.MaxAttempts, err = getEnvInt("RETRY_MAX_ATTEMPTS", cfg.MaxAttempts); err != nil {
+        return err
+    }
+    if cfg.Backoff, err = getEnvDuration("RETRY_BACKOFF", cfg.Backoff); err != nil {
+        return err
+    }
+    if cfg.MaxBackoff, err = getEnvDuration("RETRY_MAX_BACKOFF", cfg.MaxBackoff); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyBreakerEnv đọc các biến môi trường BREAKER_*
+func applyBreakerEnv(cfg *BreakerConfig) error {
+    cfg.Enabled = getEnvBool("BREAKER_ENABLED", cfg.Enabled)
+
+    var err error
+    if cfg.FailureThreshold, err = getEnvInt("BREAKER_FAILURE_THRESHOLD", cfg.FailureThreshold); err != nil {
+        return err
+    }
+    if v := os.Getenv("BREAKER_ERROR_RATE"); v != "" {
+        if cfg.ErrorRate, err = strconv.ParseFloat(v, 64); err != nil {
+            return fmt.Errorf("invalid BREAKER_ERROR_RATE %q", v)
+        }
+    }
+    if cfg.WindowSize, err = getEnvInt("BREAKER_WINDOW_SIZE", cfg.WindowSize); err != nil {
+        return err
+    }
+    if cfg.MinRequests, err = getEnvInt("BREAKER_MIN_REQUESTS", cfg.MinRequests); err != nil {
+        return err
+    }
+    if cfg.Cooldown, err = getEnvDuration("BREAKER_COOLDOWN", cfg.Cooldown); err != nil {
+        return err
+    }
+    if cfg.HalfOpenMaxRequests, err = getEnvInt("BREAKER_HALF_OPEN_REQUESTS", cfg.HalfOpenMaxRequests); err != nil {
+        return err
+    }
+    return nil
+}
+
+// applyListenerEnv thêm listener gateway và pool khai báo bằng GATEWAY_* và POOL_*,
+// listener cùng tên trong file cấu hình bị thay thế
+func applyListenerEnv(cfg *Config, sessionHeader string) error {
+    for _, env := range []struct{ prefix, name, mode string }{
+        {"GATEWAY", "gateway", ModeGateway},
+        {"POOL", "pool", ModePool},
+    } {
+        listener, err := loadListenerFromEnv(env.prefix, env.mode)
+        if err != nil {
+            return err
+        }
+        if listener == nil {
+            continue
+        }
+        listener.Name = env.name
+        listener.SessionHeader = sessionHeader
+
+        replaced := false
+        for i := range cfg.Listeners {
+            if cfg.Listeners[i].Name == listener.Name {
+                cfg.Listeners[i] = *listener
+                replaced = true
+            }
+        }
+        if !replaced {
+            cfg.Listeners = append(cfg.Listeners, *listener)
+        }
+    }
+    return nil
+}
+
+// loadListenerFromEnv đọc cấu hình listener gateway/pool từ các biến môi trường <prefix>_*,
+// listener chỉ được bật khi có <prefix>_PORT
+func loadListenerFromEnv(prefix, mode string) (*ProxyConfig, error) {
+    portStr := os.Getenv(prefix + "_PORT")
+    if portStr == "" {
+        return nil, nil
+    }
+
+    port, err := strconv.Atoi(portStr)
+    if err != nil {
+        return nil, fmt.Errorf("invalid %s_PORT %q", prefix, portStr)
+    }
+
+    socksPort, err := getEnvInt(prefix+"_SOCKS_PORT", 0)
+    if err != nil {
+        return nil, err
+    }
+
+    authPass := os.Getenv(prefix + "_PASS")
+    if authPass == "" {
+        return nil, fmt.Errorf("%s_PASS is required when %s_PORT is set", prefix, prefix)
+    }
+
+    cfg := &ProxyConfig{
+        ServerHost: getEnv(prefix+"_HOST", "0.0.0.0"),
+        ServerPort: port,
+        SocksPort:  socksPort,
+        Mode:       mode,
+        Users: []UserConfig{{
+            Username: getEnv(prefix+"_USER", "user"),
+            Password: authPass,
+        }},
+        RequireAuth: true,
+    }
+    applyAccessEnv(prefix, &cfg.Access)
+    cfg.ConnectPorts = getEnvList(prefix+"_CONNECT_PORTS", nil)
+    if mode == ModePool {
+        cfg.PoolName = os.Getenv(prefix + "_NAME")
+        cfg.Strategy = getEnv(prefix+"_STRATEGY", "round-robin")
+    }
+    return cfg, nil
+}
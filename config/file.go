@@ -0,0 +1,398 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// fileConfig là cấu trúc của file cấu hình YAML/JSON (JSON được đọc như YAML)
+type fileConfig struct {
+    // UpstreamFiles là các file danh sách host:port:user:pass cần import
+    UpstreamFiles []upstreamFile   `yaml:"upstream_files"`
+    Upstreams     []fileUpstream   `yaml:"upstreams"`
+    Pools         []PoolConfig     `yaml:"pools"`
+    Users         []UserConfig     `yaml:"users"`
+    // UserStore là file htpasswd hoặc .json chứa tài khoản với mật khẩu đã hash
+    UserStore     string           `yaml:"user_store"`
+    PerPort       perPortConfig    `yaml:"per_port"`
+    Listeners     []fileListener   `yaml:"listeners"`
+    // Strict từ chối khởi động khi danh sách upstream có dòng không hợp lệ
+    Strict        bool             `yaml:"strict"`
+    // Watch reload cấu hình khi file cấu hình hoặc danh sách upstream thay đổi
+    Watch         bool             `yaml:"watch"`
+
+    Strategy      string           `yaml:"strategy"`
+    SessionTTL    time.Duration    `yaml:"session_ttl"`
+    SessionHeader string           `yaml:"session_header"`
+
+    Timeouts      TimeoutConfig     `yaml:"timeouts"`
+    HealthCheck   HealthCheckConfig `yaml:"health_check"`
+    Retry         RetryConfig       `yaml:"retry"`
+    Breaker       BreakerConfig     `yaml:"breaker"`
+    // Bandwidth giới hạn tổng băng thông mỗi chiều (byte/giây), ví dụ 10MB
+    Bandwidth     ByteSize          `yaml:"bandwidth"`
+    Admin         AdminConfig       `yaml:"admin"`
+    Metrics       MetricsConfig     `yaml:"metrics"`
+    Accounting    AccountingConfig  `yaml:"accounting"`
+    Quotas        QuotaConfig       `yaml:"quotas"`
+    Lockout       LockoutConfig     `yaml:"lockout"`
+    DestinationGuard DestinationGuardConfig `yaml:"destination_guard"`
+    Groups        map[string][]string `yaml:"groups"`
+    ACL           []ACLRule         `yaml:"acl"`
+}
+
+// upstreamFile là một file danh sách upstream, Pool áp dụng cho entry trước section đầu tiên
+type upstreamFile struct {
+    Path string `yaml:"path"`
+    Pool string `yaml:"pool"`
+}
+
+// fileUpstream khai báo một upstream, URL nhận cả dạng host:port:user:pass
+type fileUpstream struct {
+    Name   string `yaml:"name"`
+    URL    string `yaml:"url"`
+    Pool   string `yaml:"pool"`
+    Weight int    `yaml:"weight"`
+}
+
+// perPortConfig mở một listener riêng cho mỗi upstream, port tăng dần từ StartPort
+type perPortConfig struct {
+    Enabled     bool     `yaml:"enabled"`
+    Host        string   `yaml:"host"`
+    StartPort   int      `yaml:"start_port"`
+    // SocksOffset là khoảng cách giữa HTTP port và SOCKS5 port, 0 = tắt SOCKS5
+    SocksOffset int      `yaml:"socks_offset"`
+    // Users rỗng thì mỗi listener có tài khoản riêng user<port>/pass<port>
+    Users       []string `yaml:"users"`
+    // Bandwidth giới hạn băng thông của từng listener
+    Bandwidth   ByteSize `yaml:"bandwidth"`
+    Access      AccessConfig `yaml:"access"`
+    // ConnectPorts là port CONNECT được phép của từng listener, rỗng = 443
+    ConnectPorts []string `yaml:"connect_ports"`
+}
+
+type fileListener struct {
+    Name          string   `yaml:"name"`
+    // Mode: single (mặc định), gateway hoặc pool
+    Mode          string   `yaml:"mode"`
+    Host          string   `yaml:"host"`
+    Port          int      `yaml:"port"`
+    SocksPort     int      `yaml:"socks_port"`
+    Upstream      string   `yaml:"upstream"`
+    Pool          string   `yaml:"pool"`
+    Strategy      string   `yaml:"strategy"`
+    SessionHeader string   `yaml:"session_header"`
+    RequireAuth   *bool    `yaml:"require_auth"`
+    Users         []string `yaml:"users"`
+    Bandwidth     ByteSize `yaml:"bandwidth"`
+    Access        AccessConfig `yaml:"access"`
+    ConnectPorts  []string `yaml:"connect_ports"`
+}
+
+// defaultFileConfig trả về cấu hình mặc định, tương đương cách chạy trước khi có file cấu hình
+func defaultFileConfig() fileConfig {
+    return fileConfig{
+        UpstreamFiles: []upstreamFile{{Path: "list_proxy.txt"}},
+        PerPort: perPortConfig{
+            Enabled:     true,
+            Host:        "0.0.0.0",
+            StartPort:   3000,
+            SocksOffset: 1000,
+        },
+        Watch:         true,
+        Strategy:      "round-robin",
+        SessionTTL:    30 * time.Minute,
+        SessionHeader: "X-Proxy-Session",
+        Timeouts: TimeoutConfig{
+            Dial:     30 * time.Second,
+            Read:     30 * time.Second,
+            Write:    30 * time.Second,
+            Idle:     120 * time.Second,
+            Request:  120 * time.Second,
+            Shutdown: 30 * time.Second,
+        },
+        HealthCheck: HealthCheckConfig{
+            Interval:           30 * time.Second,
+            Timeout:            10 * time.Second,
+            ConnectTarget:      "www.google.com:443",
+            HealthyThreshold:   2,
+            UnhealthyThreshold: 3,
+        },
+        Retry: RetryConfig{
+            MaxAttempts: 3,
+            Backoff:     100 * time.Millisecond,
+            MaxBackoff:  2 * time.Second,
+        },
+        Breaker: BreakerConfig{
+            Enabled:             true,
+            FailureThreshold:    5,
+            ErrorRate:           0.5,
+            WindowSize:          20,
+            MinRequests:         10,
+            Cooldown:            30 * time.Second,
+            HalfOpenMaxRequests: 1,
+        },
+        Admin: AdminConfig{
+            Username: "admin",
+        },
+        Accounting: AccountingConfig{
+            FlushInterval: time.Minute,
+            Retention:     90 * 24 * time.Hour,
+        },
+        Quotas: QuotaConfig{
+            File:          "quota.json",
+            FlushInterval: time.Minute,
+        },
+        Lockout: LockoutConfig{
+            Enabled:     true,
+            MaxFailures: 10,
+            Window:      10 * time.Minute,
+            BanDuration: 15 * time.Minute,
+            MaxBan:      24 * time.Hour,
+            Delay:       250 * time.Millisecond,
+            MaxDelay:    3 * time.Second,
+        },
+        DestinationGuard: DestinationGuardConfig{
+            Enabled: true,
+        },
+    }
+}
+
+// readFileConfig đọc file cấu hình đè lên các giá trị mặc định trong fc,
+// trường không khai báo trong file giữ nguyên giá trị mặc định
+func readFileConfig(path string, fc *fileConfig) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    decoder := yaml.NewDecoder(file)
+    decoder.KnownFields(true)
+    if err := decoder.Decode(fc); err != nil && !errors.Is(err, io.EOF) {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    return nil
+}
+
+// build chuyển cấu hình file thành Config: import danh sách upstream,
+// đặt tên upstream, tạo per-port listener và gắn tài khoản cho listener.
+// Lỗi của từng entry được gom lại để báo cáo một lần, entry lỗi bị bỏ qua
+func (fc *fileConfig) build() (*Config, error) {
+    cfg := &Config{
+        Pools:       fc.Pools,
+        Strategy:    fc.Strategy,
+        SessionTTL:  fc.SessionTTL,
+        Timeouts:    fc.Timeouts,
+        HealthCheck: fc.HealthCheck,
+        Retry:       fc.Retry,
+        Breaker:     fc.Breaker,
+        Bandwidth:   fc.Bandwidth,
+        Watch:       fc.Watch,
+        Admin:       fc.Admin,
+        Metrics:     fc.Metrics,
+        Accounting:  fc.Accounting,
+        Quotas:      fc.Quotas,
+        Lockout:     fc.Lockout,
+        DestinationGuard: fc.DestinationGuard,
+        Groups:      fc.Groups,
+        ACL:         fc.ACL,
+    }
+    var errs []error
+
+    for _, u := range fc.Upstreams {
+        upstream, err := ParseUpstream(u.URL)
+        if err != nil {
+            errs = append(errs, fmt.Errorf("upstream %q: %w", u.Name, err))
+            continue
+        }
+        upstream.Name = u.Name
+        upstream.Pool = u.Pool
+        if u.Weight > 0 {
+            upstream.Weight = u.Weight
+        }
+        cfg.Upstreams = append(cfg.Upstreams, upstream)
+    }
+
+    for _, f := range fc.UpstreamFiles {
+        upstreams, problems, err := loadUpstreamList(f.Path, f.Pool)
+        if err != nil {
+            errs = append(errs, fmt.Errorf("load upstream list: %w", err))
+            continue
+        }
+        cfg.Upstreams = append(cfg.Upstreams, upstreams...)
+        cfg.ListErrors = append(cfg.ListErrors, problems...)
+        cfg.Files = append(cfg.Files, f.Path)
+    }
+    assignUpstreamNames(cfg.Upstreams)
+
+    users := make(map[string]UserConfig)
+    for _, u := range fc.Users {
+        if u.Username == "" || u.Password == "" && u.PasswordHash == "" {
+            errs = append(errs, fmt.Errorf("user %q: username and password or password_hash are required", u.Username))
+            continue
+        }
+        if u.PasswordHash != "" {
+            if err := CheckPasswordHash(u.PasswordHash); err != nil {
+                errs = append(errs, fmt.Errorf("user %q: %w", u.Username, err))
+                continue
+            }
+        }
+        if _, ok := users[u.Username]; ok {
+            errs = append(errs, fmt.Errorf("duplicate user %q", u.Username))
+            continue
+        }
+        users[u.Username] = u
+    }
+
+    var storeUsers []StoreUser
+    if fc.UserStore != "" {
+        // File được theo dõi cả khi lỗi để reload lại sau khi sửa
+        cfg.Files = append(cfg.Files, fc.UserStore)
+        loaded, err := LoadUserStore(fc.UserStore)
+        if err != nil {
+            errs = append(errs, fmt.Errorf("load user store: %w", err))
+        }
+        for _, u := range loaded {
+            if _, ok := users[u.Username]; ok {
+                errs = append(errs, fmt.Errorf("user store: user %q is also declared in users", u.Username))
+                continue
+            }
+            users[u.Username] = u.UserConfig
+            storeUsers = append(storeUsers, u)
+        }
+    }
+    lookupUsers := func(owner string, names []string) []UserConfig {
+        var list []UserConfig
+        for _, name := range names {
+            u, ok := users[name]
+            if !ok {
+                errs = append(errs, fmt.Errorf("%s: unknown user %q", owner, name))
+                continue
+            }
+            list = append(list, u)
+        }
+        return list
+    }
+    // grantStoreUsers thêm vào listener các tài khoản trong user store được phép dùng nó
+    grantStoreUsers := func(l *ProxyConfig) {
+        upstreamPool := ""
+        if u, ok := cfg.Upstream(l.Upstream); ok && l.Mode == ModeSingle {
+            upstreamPool = u.Pool
+        }
+        for i := range storeUsers {
+            if storeUsers[i].Allowed(l, upstreamPool) && !hasUser(l.Users, storeUsers[i].Username) {
+                l.Users = append(l.Users, storeUsers[i].UserConfig)
+            }
+        }
+    }
+
+    if fc.PerPort.Enabled {
+        perPortUsers := lookupUsers("per_port", fc.PerPort.Users)
+
+        for i, upstream := range cfg.Upstreams {
+            port := fc.PerPort.StartPort + i
+            listener := ProxyConfig{
+                Name:        fmt.Sprintf("port-%d", port),
+                ServerHost:  fc.PerPort.Host,
+                ServerPort:  port,
+                Upstream:    upstream.Name,
+                Users:       append([]UserConfig(nil), perPortUsers...),
+                RequireAuth: true,
+                Bandwidth:   fc.PerPort.Bandwidth,
+                Access:      fc.PerPort.Access,
+                ConnectPorts: fc.PerPort.ConnectPorts,
+            }
+            if fc.PerPort.SocksOffset != 0 {
+                listener.SocksPort = port + fc.PerPort.SocksOffset
+            }
+
+            grantStoreUsers(&listener)
+
+            if len(listener.Users) == 0 && fc.UserStore == "" {
+                // Không có user store: tạo tài khoản user<port> với mật khẩu ngẫu nhiên
+                user, err := generatedUser(port, listener.SocksPort, upstream)
+                if err != nil {
+                    errs = append(errs, err)
+                }
+                listener.Users = []UserConfig{user}
+            }
+
+            cfg.Listeners = append(cfg.Listeners, listener)
+        }
+    }
+
+    for _, l := range fc.Listeners {
+        listener := ProxyConfig{
+            Name:          l.Name,
+            ServerHost:    l.Host,
+            ServerPort:    l.Port,
+            SocksPort:     l.SocksPort,
+            Mode:          l.Mode,
+            Upstream:      l.Upstream,
+            PoolName:      l.Pool,
+            Strategy:      l.Strategy,
+            SessionHeader: l.SessionHeader,
+            RequireAuth:   l.RequireAuth == nil || *l.RequireAuth,
+            Bandwidth:     l.Bandwidth,
+            Access:        l.Access,
+            ConnectPorts:  l.ConnectPorts,
+        }
+        if listener.Mode == "single" {
+            listener.Mode = ModeSingle
+        }
+        if listener.Name == "" {
+            listener.Name = fmt.Sprintf("port-%d", l.Port)
+        }
+        if listener.ServerHost == "" {
+            listener.ServerHost = "0.0.0.0"
+        }
+        if listener.SessionHeader == "" && listener.Mode != ModeSingle {
+            listener.SessionHeader = fc.SessionHeader
+        }
+
+        listener.Users = lookupUsers("listener "+listener.Name, l.Users)
+        grantStoreUsers(&listener)
+        cfg.Listeners = append(cfg.Listeners, listener)
+    }
+
+    return cfg, errors.Join(errs...)
+}
+
+func hasUser(users []UserConfig, username string) bool {
+    for _, u := range users {
+        if u.Username == username {
+            return true
+        }
+    }
+    return false
+}
+
+// assignUpstreamNames đặt tên host:port cho upstream chưa có tên, thêm hậu tố #n nếu trùng
+func assignUpstreamNames(upstreams []UpstreamConfig) {
+    used := make(map[string]bool)
+    for _, u := range upstreams {
+        if u.Name != "" {
+            used[u.Name] = true
+        }
+    }
+
+    for i := range upstreams {
+        if upstreams[i].Name != "" {
+            continue
+        }
+
+        base := upstreams[i].GetProxyAddress()
+        name := base
+        for n := 2; used[name]; n++ {
+            name = fmt.Sprintf("%s#%d", base, n)
+        }
+        used[name] = true
+        upstreams[i].Name = name
+    }
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ByteSize là số byte, trong file cấu hình nhận số nguyên hoặc chuỗi có đơn vị
+// như 500MB, 10GB (bội số 1000) hoặc 1GiB (bội số 1024)
+type ByteSize int64
+
+var byteUnits = []struct {
+    suffix string
+    size   int64
+}{
+    {"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+    {"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+    {"B", 1},
+}
+
+// ParseByteSize đọc kích thước dạng 1048576, 500MB, 1.5GB hoặc 1GiB
+func ParseByteSize(s string) (ByteSize, error) {
+    s = strings.TrimSpace(s)
+    multiplier := int64(1)
+    number := s
+    for _, unit := range byteUnits {
+        if len(s) > len(unit.suffix) && strings.EqualFold(s[len(s)-len(unit.suffix):], unit.suffix) {
+            multiplier = unit.size
+            number = strings.TrimSpace(s[:len(s)-len(unit.suffix)])
+            break
+        }
+    }
+
+    value, err := strconv.ParseFloat(number, 64)
+    if err != nil || value < 0 {
+        return 0, fmt.Errorf("invalid size %q (expected bytes or a number with B, KB, MB, GB, TB, KiB, MiB, GiB or TiB)", s)
+    }
+    return ByteSize(value * float64(multiplier)), nil
+}
+
+// UnmarshalYAML nhận cả số nguyên và chuỗi có đơn vị
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+    size, err := ParseByteSize(value.Value)
+    if err != nil {
+        return fmt.Errorf("line %d: %w", value.Line, err)
+    }
+    *b = size
+    return nil
+}
+
+// UnmarshalJSON nhận số byte hoặc chuỗi có đơn vị, dùng cho body của admin API
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        var n json.Number
+        if err := json.Unmarshal(data, &n); err != nil {
+            return fmt.Errorf("invalid size %s", data)
+        }
+        s = n.String()
+    }
+    size, err := ParseByteSize(s)
+    if err != nil {
+        return err
+    }
+    *b = size
+    return nil
+}
+
+// String trả về kích thước với đơn vị lớn nhất chia hết, ví dụ 10GB hoặc 1GiB
+func (b ByteSize) String() string {
+    for _, unit := range byteUnits {
+        if b != 0 && int64(b)%unit.size == 0 {
+            return fmt.Sprintf("%d%s", int64(b)/unit.size, unit.suffix)
+        }
+    }
+    return "0B"
+}
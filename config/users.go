@@ -0,0 +1,347 @@
+package config
+
+import (
+    "bufio"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "proxy-server/utils"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+
+    "go.uber.org/zap"
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// StoreUser là tài khoản trong user store cùng các listener được phép dùng.
+// Tài khoản được gắn vào listener có HTTP port trong Ports, listener pool có pool
+// trong Pools, listener single có upstream thuộc Pools, hoặc mọi listener nếu All.
+// Tài khoản không có quyền nào chỉ dùng được ở listener liệt kê tên nó trong users
+type StoreUser struct {
+    UserConfig
+    Ports []int
+    Pools []string
+    All   bool
+}
+
+// storeFile là định dạng JSON của user store
+type storeFile struct {
+    Users []storeEntry `json:"users"`
+}
+
+type storeEntry struct {
+    Username     string   `json:"username"`
+    PasswordHash string   `json:"password_hash"`
+    Ports        []int    `json:"ports,omitempty"`
+    Pools        []string `json:"pools,omitempty"`
+    All          bool     `json:"all,omitempty"`
+}
+
+// LoadUserStore đọc user store: file .json, hoặc file htpasswd với mỗi dòng
+// username:hash[:quyền], quyền là danh sách port, pool=<tên> hoặc * cách nhau bởi dấu phẩy
+func LoadUserStore(path string) ([]StoreUser, error) {
+    var users []StoreUser
+    var err error
+    if strings.EqualFold(filepath.Ext(path), ".json") {
+        users, err = loadJSONStore(path)
+    } else {
+        users, err = loadHtpasswd(path)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    seen := make(map[string]bool)
+    for _, u := range users {
+        if u.Username == "" {
+            return nil, fmt.Errorf("%s: user without username", path)
+        }
+        if seen[u.Username] {
+            return nil, fmt.Errorf("%s: duplicate user %q", path, u.Username)
+        }
+        seen[u.Username] = true
+        if err := CheckPasswordHash(u.PasswordHash); err != nil {
+            return nil, fmt.Errorf("%s: user %q: %w", path, u.Username, err)
+        }
+    }
+    return users, nil
+}
+
+func loadJSONStore(path string) ([]StoreUser, error) {
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var data storeFile
+    if err := json.Unmarshal(raw, &data); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+
+    users := make([]StoreUser, 0, len(data.Users))
+    for _, u := range data.Users {
+        users = append(users, StoreUser{
+            UserConfig: UserConfig{Username: u.Username, PasswordHash: u.PasswordHash},
+            Ports:      u.Ports,
+            Pools:      u.Pools,
+            All:        u.All,
+        })
+    }
+    return users, nil
+}
+
+func loadHtpasswd(path string) ([]StoreUser, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var users []StoreUser
+    scanner := bufio.NewScanner(file)
+    for lineNum := 1; scanner.Scan(); lineNum++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        fields := strings.SplitN(line, ":", 3)
+        if len(fields) < 2 {
+            return nil, fmt.Errorf("%s:%d: expected username:hash", path, lineNum)
+        }
+        user := StoreUser{UserConfig: UserConfig{Username: fields[0], PasswordHash: fields[1]}}
+        if len(fields) == 3 {
+            if err := user.parseGrants(fields[2]); err != nil {
+                return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+            }
+        }
+        users = append(users, user)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    return users, nil
+}
+
+// SaveUserStore ghi lại toàn bộ user store theo định dạng của LoadUserStore (chọn theo
+// phần mở rộng của path). Comment trong file htpasswd cũ không được giữ lại
+func SaveUserStore(path string, users []StoreUser) error {
+    var data []byte
+    if strings.EqualFold(filepath.Ext(path), ".json") {
+        file := storeFile{Users: make([]storeEntry, 0, len(users))}
+        for _, u := range users {
+            file.Users = append(file.Users, storeEntry{
+                Username:     u.Username,
+                PasswordHash: u.PasswordHash,
+                Ports:        u.Ports,
+                Pools:        u.Pools,
+                All:          u.All,
+            })
+        }
+        encoded, err := json.MarshalIndent(file, "", "  ")
+        if err != nil {
+            return err
+        }
+        data = append(encoded, '\n')
+    } else {
+        var b strings.Builder
+        for _, u := range users {
+            b.WriteString(u.Username + ":" + u.PasswordHash)
+            if grants := u.grants(); grants != "" {
+                b.WriteString(":" + grants)
+            }
+            b.WriteByte('\n')
+        }
+        data = []byte(b.String())
+    }
+    return utils.WriteFileAtomic(path, data)
+}
+
+// grants trả về quyền của tài khoản theo cú pháp của parseGrants
+func (u *StoreUser) grants() string {
+    if u.All {
+        return "*"
+    }
+    grants := make([]string, 0, len(u.Ports)+len(u.Pools))
+    for _, port := range u.Ports {
+        grants = append(grants, strconv.Itoa(port))
+    }
+    for _, pool := range u.Pools {
+        grants = append(grants, "pool="+pool)
+    }
+    return strings.Join(grants, ",")
+}
+
+// parseGrants đọc danh sách quyền dạng 3000,3001,pool=us hoặc *
+func (u *StoreUser) parseGrants(s string) error {
+    for _, grant := range strings.Split(s, ",") {
+        grant = strings.TrimSpace(grant)
+        switch {
+        case grant == "":
+        case grant == "*":
+            u.All = true
+        case strings.HasPrefix(grant, "pool="):
+            u.Pools = append(u.Pools, strings.TrimPrefix(grant, "pool="))
+        default:
+            port, err := strconv.Atoi(grant)
+            if err != nil || port <= 0 || port > 65535 {
+                return fmt.Errorf("invalid grant %q (expected a port, pool=<name> or *)", grant)
+            }
+            u.Ports = append(u.Ports, port)
+        }
+    }
+    return nil
+}
+
+// Allowed cho biết tài khoản có được gắn vào listener không, upstreamPool là pool
+// của upstream với listener single
+func (u *StoreUser) Allowed(l *ProxyConfig, upstreamPool string) bool {
+    if u.All || slices.Contains(u.Ports, l.ServerPort) {
+        return true
+    }
+    switch l.Mode {
+    case ModePool:
+        return l.PoolName != "" && slices.Contains(u.Pools, l.PoolName)
+    case ModeSingle:
+        return upstreamPool != "" && slices.Contains(u.Pools, upstreamPool)
+    }
+    return false
+}
+
+// passwordBytes là số byte ngẫu nhiên của mật khẩu sinh tự động (24 ký tự base64)
+const passwordBytes = 18
+
+// GeneratePassword sinh mật khẩu ngẫu nhiên an toàn, chỉ gồm ký tự dùng được trong URL
+func GeneratePassword() (string, error) {
+    buf := make([]byte, passwordBytes)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// generatedUsers giữ mật khẩu ngẫu nhiên đã tạo theo port, để reload không đổi mật khẩu
+var (
+    generatedMu    sync.Mutex
+    generatedUsers = make(map[int]UserConfig)
+)
+
+// generatedUser trả về tài khoản user<port> với mật khẩu ngẫu nhiên cho listener per-port
+// khi không có user store. Mật khẩu bị che trong log trừ khi bật --reveal-secrets
+func generatedUser(port, socksPort int, upstream UpstreamConfig) (UserConfig, error) {
+    generatedMu.Lock()
+    defer generatedMu.Unlock()
+
+    if user, ok := generatedUsers[port]; ok {
+        return user, nil
+    }
+    password, err := GeneratePassword()
+    if err != nil {
+        return UserConfig{}, fmt.Errorf("generate password for port %d: %v", port, err)
+    }
+    user := UserConfig{
+        Username: fmt.Sprintf("user%d", port),
+        Password: password,
+    }
+    generatedUsers[port] = user
+
+    // Lệnh validate không khởi tạo logger
+    logger := utils.GetLogger()
+    if logger == nil {
+        return user, nil
+    }
+    logger.Warn("Generated random credentials, configure a user store (see gen-creds) to keep them across restarts",
+        zap.Int("port", port),
+        zap.Int("socks_port", socksPort),
+        zap.String("upstream", upstream.Name),
+        zap.String("username", user.Username),
+        zap.String("password", utils.RedactSecret(user.Password)),
+    )
+    return user, nil
+}
+
+// CheckPasswordHash kiểm tra hash có định dạng được hỗ trợ: bcrypt ($2a$, $2b$, $2y$) hoặc argon2id
+func CheckPasswordHash(hash string) error {
+    if strings.HasPrefix(hash, "$argon2id$") {
+        _, err := ParseArgon2id(hash)
+        return err
+    }
+    for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+        if strings.HasPrefix(hash, prefix) {
+            if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+                return fmt.Errorf("invalid bcrypt hash: %v", err)
+            }
+            return nil
+        }
+    }
+    return fmt.Errorf("unsupported password hash (expected bcrypt or argon2id)")
+}
+
+// maxArgon2Memory giới hạn tham số m (KiB) của hash argon2id, lớn hơn bị coi là hash hỏng
+const maxArgon2Memory = 4 << 20
+
+// maxArgon2KeyLen giới hạn độ dài khóa của hash argon2id
+const maxArgon2KeyLen = 1024
+
+// Argon2idHash là tham số, salt và khóa của hash argon2id
+type Argon2idHash struct {
+    Memory  uint32
+    Time    uint32
+    Threads uint8
+    Salt    []byte
+    Key     []byte
+}
+
+// ParseArgon2id đọc hash dạng PHC $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key> (base64 không padding).
+// t hoặc p bằng 0 làm argon2.IDKey panic nên bị từ chối, m quá lớn cũng bị từ chối
+func ParseArgon2id(hash string) (*Argon2idHash, error) {
+    parts := strings.Split(hash, "$")
+    if len(parts) != 6 || parts[1] != "argon2id" {
+        return nil, fmt.Errorf("invalid argon2id hash: expected $argon2id$v=..$m=..,t=..,p=..$salt$key")
+    }
+    if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+        return nil, fmt.Errorf("invalid argon2id hash: unsupported version %q", parts[2])
+    }
+
+    // Tham số theo đúng thứ tự m, t, p
+    var values [3]uint64
+    params := strings.Split(parts[3], ",")
+    for i, name := range []string{"m", "t", "p"} {
+        value, ok := "", false
+        if len(params) == 3 {
+            value, ok = strings.CutPrefix(params[i], name+"=")
+        }
+        n, err := strconv.ParseUint(value, 10, 32)
+        if !ok || err != nil {
+            return nil, fmt.Errorf("invalid argon2id hash: bad parameters %q", parts[3])
+        }
+        values[i] = n
+    }
+    switch {
+    case values[0] > maxArgon2Memory:
+        return nil, fmt.Errorf("invalid argon2id hash: m must be at most %d", maxArgon2Memory)
+    case values[1] == 0:
+        return nil, fmt.Errorf("invalid argon2id hash: t must be at least 1")
+    case values[2] == 0 || values[2] > 255:
+        return nil, fmt.Errorf("invalid argon2id hash: p must be between 1 and 255")
+    }
+    h := &Argon2idHash{Memory: uint32(values[0]), Time: uint32(values[1]), Threads: uint8(values[2])}
+
+    var err error
+    if h.Salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+        return nil, fmt.Errorf("invalid argon2id hash: salt: %v", err)
+    }
+    if h.Key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+        return nil, fmt.Errorf("invalid argon2id hash: key: %v", err)
+    }
+    if len(h.Key) == 0 || len(h.Key) > maxArgon2KeyLen {
+        return nil, fmt.Errorf("invalid argon2id hash: key must be 1 to %d bytes", maxArgon2KeyLen)
+    }
+    return h, nil
+}
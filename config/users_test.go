@@ -0,0 +1,84 @@
+package config
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestParseArgon2id(t *testing.T) {
+    const salt, key = "MDEyMzQ1Njc4OWFiY2RlZg", "c2VjcmV0LWtleS1ieXRlcw"
+
+    tests := []struct {
+        hash    string
+        wantErr string
+    }{
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$" + key},
+        {hash: "$argon2id$v=19$m=64,t=1,p=255$" + salt + "$" + key},
+        {hash: "$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key, wantErr: "t must be"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key, wantErr: "p must be"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=256$" + salt + "$" + key, wantErr: "p must be"},
+        {hash: "$argon2id$v=19$m=4194305,t=3,p=4$" + salt + "$" + key, wantErr: "m must be"},
+        {hash: "$argon2id$v=19$m=99999999999,t=3,p=4$" + salt + "$" + key, wantErr: "bad parameters"},
+        {hash: "$argon2id$v=19$t=3,m=65536,p=4$" + salt + "$" + key, wantErr: "bad parameters"},
+        {hash: "$argon2id$v=19$m=65536,t=3$" + salt + "$" + key, wantErr: "bad parameters"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4,x=1$" + salt + "$" + key, wantErr: "bad parameters"},
+        {hash: "$argon2id$v=19$m=-1,t=3,p=4$" + salt + "$" + key, wantErr: "bad parameters"},
+        {hash: "$argon2id$v=16$m=65536,t=3,p=4$" + salt + "$" + key, wantErr: "unsupported version"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$", wantErr: "key must be"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$!!", wantErr: "key:"},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4$" + salt, wantErr: "expected"},
+        {hash: "$argon2i$v=19$m=65536,t=3,p=4$" + salt + "$" + key, wantErr: "expected"},
+    }
+    for _, tt := range tests {
+        h, err := ParseArgon2id(tt.hash)
+        if tt.wantErr == "" {
+            if err != nil {
+                t.Errorf("ParseArgon2id(%q) = %v", tt.hash, err)
+            }
+            continue
+        }
+        if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+            t.Errorf("ParseArgon2id(%q) = %+v, %v, want error containing %q", tt.hash, h, err, tt.wantErr)
+        }
+    }
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+    tests := []struct {
+        hash    string
+        wantErr bool
+    }{
+        {hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+        {hash: "$2b$04$abcdefghijklmnopqrstuu5Y4wsgzGFbC1V7ffbqUr0Gm0qgYqHe6"},
+        {hash: "$2y$10$short", wantErr: true},
+        {hash: "$argon2id$v=19$m=65536,t=3,p=4$MDEyMzQ1Njc4OWFiY2RlZg$c2VjcmV0LWtleS1ieXRlcw"},
+        {hash: "$argon2id$v=19$m=65536,t=0,p=4$MDEyMzQ1Njc4OWFiY2RlZg$c2VjcmV0LWtleS1ieXRlcw", wantErr: true},
+        {hash: "$argon2id$", wantErr: true},
+        {hash: "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", wantErr: true},
+        {hash: "plaintext", wantErr: true},
+    }
+    for _, tt := range tests {
+        if err := CheckPasswordHash(tt.hash); (err != nil) != tt.wantErr {
+            t.Errorf("CheckPasswordHash(%q) = %v, want error %v", tt.hash, err, tt.wantErr)
+        }
+    }
+}
+
+func TestGeneratedUserKeptPerPort(t *testing.T) {
+    first, err := generatedUser(13000, 14000, UpstreamConfig{Name: "a"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, _ := generatedUser(13000, 14000, UpstreamConfig{Name: "a"})
+    other, _ := generatedUser(13001, 14001, UpstreamConfig{Name: "b"})
+
+    if first.Username != "user13000" || len(first.Password) != 24 {
+        t.Errorf("generatedUser() = %+v, want user13000 with a 24 character password", first)
+    }
+    if again.Password != first.Password {
+        t.Errorf("password changed for the same port: %q, then %q", first.Password, again.Password)
+    }
+    if other.Password == first.Password {
+        t.Error("two ports got the same password")
+    }
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce gộp các thay đổi liên tiếp (editor thường ghi file nhiều lần) thành một lần reload
+const watchDebounce = 500 * time.Millisecond
+
+// Watcher theo dõi các file cấu hình và gọi onChange khi một trong số chúng thay đổi.
+// Watcher theo dõi thư mục chứa file để vẫn nhận được thay đổi khi editor ghi file mới rồi rename
+type Watcher struct {
+    watcher  *fsnotify.Watcher
+    onChange func()
+
+    mu    sync.Mutex
+    files map[string]bool
+    dirs  map[string]bool
+    timer *time.Timer
+    done  chan struct{}
+}
+
+// NewWatcher tạo watcher, gọi Watch để chọn các file cần theo dõi
+func NewWatcher(onChange func()) (*Watcher, error) {
+    fsw, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+
+    w := &Watcher{
+        watcher:  fsw,
+        onChange: onChange,
+        files:    make(map[string]bool),
+        dirs:     make(map[string]bool),
+        done:     make(chan struct{}),
+    }
+    go w.run()
+    return w, nil
+}
+
+// Watch thay danh sách file được theo dõi, gọi lại sau mỗi lần reload vì danh sách có thể đổi
+func (w *Watcher) Watch(files []string) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    w.files = make(map[string]bool)
+    dirs := make(map[string]bool)
+    for _, f := range files {
+        abs, err := filepath.Abs(f)
+        if err != nil {
+            return err
+        }
+        w.files[abs] = true
+        dirs[filepath.Dir(abs)] = true
+    }
+
+    for dir := range w.dirs {
+        if !dirs[dir] {
+            w.watcher.Remove(dir)
+        }
+    }
+    for dir := range dirs {
+        if !w.dirs[dir] {
+            if err := w.watcher.Add(dir); err != nil {
+                return err
+            }
+        }
+    }
+    w.dirs = dirs
+    return nil
+}
+
+// Close dừng theo dõi
+func (w *Watcher) Close() error {
+    err := w.watcher.Close()
+    <-w.done
+
+    w.mu.Lock()
+    if w.timer != nil {
+        w.timer.Stop()
+    }
+    w.mu.Unlock()
+    return err
+}
+
+func (w *Watcher) run() {
+    defer close(w.done)
+
+    for {
+        select {
+        case event, ok := <-w.watcher.Events:
+            if !ok {
+                return
+            }
+            if event.Op == fsnotify.Chmod {
+                continue
+            }
+
+            w.mu.Lock()
+            if w.files[filepath.Clean(event.Name)] {
+                if w.timer != nil {
+                    w.timer.Stop()
+                }
+                w.timer = time.AfterFunc(watchDebounce, w.onChange)
+            }
+            w.mu.Unlock()
+        case _, ok := <-w.watcher.Errors:
+            if !ok {
+                return
+            }
+        }
+    }
+}
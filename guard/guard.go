@@ -0,0 +1,141 @@
+package guard
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/netip"
+    "proxy-server/config"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// BlockedError cho biết đích đến phân giải ra địa chỉ nội bộ không nằm trong allowlist
+type BlockedError struct {
+    Addr   netip.Addr
+    Reason string
+}
+
+func (e *BlockedError) Error() string {
+    return e.Reason
+}
+
+// lookup phân giải tên miền của đích đến
+var lookup = net.DefaultResolver.LookupNetIP
+
+// Guard chặn đích đến là địa chỉ private, loopback, link-local, multicast, unspecified
+// hoặc thuộc các dải không công khai khác (CGNAT, benchmark, reserved).
+// Tên miền được phân giải tại proxy để kiểm tra. Chỉ upstream socks5 kết nối tới đúng
+// địa chỉ đã kiểm tra, upstream http và socks5h tự phân giải lại tên miền nên DNS
+// rebinding qua các upstream này vẫn có thể đổi đích sau khi kiểm tra
+type Guard struct {
+    mu      sync.RWMutex
+    enabled bool
+    allow   []netip.Prefix
+}
+
+// New tạo Guard chưa bật, gọi Configure để áp dụng cấu hình
+func New() *Guard {
+    return &Guard{}
+}
+
+// Configure đổi cấu hình, allowlist đã được kiểm tra trong Config.Check
+func (g *Guard) Configure(cfg config.DestinationGuardConfig) {
+    allow, _ := config.ParsePrefixes(cfg.Allow)
+
+    g.mu.Lock()
+    g.enabled = cfg.Enabled
+    g.allow = allow
+    g.mu.Unlock()
+}
+
+// Resolve kiểm tra đích host:port và trả về địa chỉ ip:port đã kiểm tra. Guard tắt thì trả về
+// nguyên host để upstream tự phân giải. Tên miền có một địa chỉ bị chặn thì cả tên miền bị chặn
+func (g *Guard) Resolve(ctx context.Context, host string, port int) (string, error) {
+    g.mu.RLock()
+    enabled, allow := g.enabled, g.allow
+    g.mu.RUnlock()
+
+    host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+    if !enabled {
+        return net.JoinHostPort(host, strconv.Itoa(port)), nil
+    }
+
+    addr, err := netip.ParseAddr(host)
+    literal := err == nil
+    addrs := []netip.Addr{addr}
+    if !literal {
+        addrs, err = lookup(ctx, "ip", host)
+        if err != nil {
+            return "", err
+        }
+        if len(addrs) == 0 {
+            return "", fmt.Errorf("no addresses for %s", host)
+        }
+    }
+
+    for _, addr := range addrs {
+        addr = addr.Unmap()
+        if slices.ContainsFunc(allow, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+            continue
+        }
+        if class := blockedClass(addr); class != "" {
+            reason := fmt.Sprintf("destination %s is not allowed (%s address)", host, class)
+            if !literal {
+                reason = fmt.Sprintf("destination %s resolves to %s, which is not allowed (%s address)", host, addr, class)
+            }
+            return "", &BlockedError{Addr: addr, Reason: reason}
+        }
+    }
+    return net.JoinHostPort(addrs[0].Unmap().String(), strconv.Itoa(port)), nil
+}
+
+// reservedPrefixes là các dải IPv4 không công khai mà netip không phân loại
+var reservedPrefixes = []struct {
+    prefix netip.Prefix
+    class  string
+}{
+    // RFC 6598, nhiều cloud dùng làm mạng nội bộ
+    {netip.MustParsePrefix("100.64.0.0/10"), "shared"},
+    // RFC 2544
+    {netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+    // RFC 6890, IETF protocol assignments
+    {netip.MustParsePrefix("192.0.0.0/24"), "reserved"},
+    // RFC 1112, gồm cả broadcast 255.255.255.255
+    {netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+}
+
+// nat64Prefix là prefix NAT64 well-known (RFC 6052), 32 bit cuối là địa chỉ IPv4
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// blockedClass trả về loại địa chỉ bị chặn, rỗng nếu addr là địa chỉ công khai
+func blockedClass(addr netip.Addr) string {
+    switch {
+    case addr.IsLoopback():
+        return "loopback"
+    case addr.IsPrivate():
+        return "private"
+    case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+        return "link-local"
+    case addr.IsMulticast(), addr.IsInterfaceLocalMulticast():
+        return "multicast"
+    case addr.IsUnspecified(), addr.Is4() && addr.As4()[0] == 0:
+        // 0.0.0.0/8 được nhiều hệ điều hành hiểu là máy cục bộ
+        return "unspecified"
+    }
+    for _, r := range reservedPrefixes {
+        if r.prefix.Contains(addr) {
+            return r.class
+        }
+    }
+    // Địa chỉ NAT64 được gateway chuyển tới IPv4 nhúng bên trong
+    if nat64Prefix.Contains(addr) {
+        b := addr.As16()
+        if class := blockedClass(netip.AddrFrom4([4]byte(b[12:]))); class != "" {
+            return class + " (nat64)"
+        }
+    }
+    return ""
+}
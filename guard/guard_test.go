@@ -0,0 +1,135 @@
+package guard
+
+import (
+    "context"
+    "errors"
+    "net/netip"
+    "proxy-server/config"
+    "testing"
+)
+
+// fakeLookup thay DNS bằng bảng tên miền cố định trong lúc chạy test
+func fakeLookup(t *testing.T, hosts map[string][]string) {
+    t.Helper()
+    old := lookup
+    lookup = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+        var addrs []netip.Addr
+        for _, a := range hosts[host] {
+            addrs = append(addrs, netip.MustParseAddr(a))
+        }
+        if len(addrs) == 0 {
+            return nil, errors.New("no such host")
+        }
+        return addrs, nil
+    }
+    t.Cleanup(func() { lookup = old })
+}
+
+func TestBlockedClass(t *testing.T) {
+    tests := []struct {
+        addr string
+        want string
+    }{
+        {"93.184.216.34", ""},
+        {"2606:2800:220:1::1", ""},
+        {"10.1.2.3", "private"},
+        {"172.16.0.1", "private"},
+        {"172.32.0.1", ""},
+        {"192.168.1.1", "private"},
+        {"fd00::1", "private"},
+        {"127.0.0.1", "loopback"},
+        {"::1", "loopback"},
+        {"169.254.169.254", "link-local"},
+        {"fe80::1", "link-local"},
+        {"224.0.0.1", "link-local"},
+        {"239.1.1.1", "multicast"},
+        {"ff02::1", "link-local"},
+        {"ff05::1", "multicast"},
+        {"0.0.0.0", "unspecified"},
+        {"0.1.2.3", "unspecified"},
+        {"::", "unspecified"},
+        {"100.64.0.1", "shared"},
+        {"100.127.255.254", "shared"},
+        {"100.128.0.1", ""},
+        {"198.18.0.1", "benchmarking"},
+        {"198.19.255.255", "benchmarking"},
+        {"198.20.0.1", ""},
+        {"192.0.0.8", "reserved"},
+        {"192.0.1.255", ""},
+        {"240.0.0.1", "reserved"},
+        {"255.255.255.255", "reserved"},
+        {"64:ff9b::a01:203", "private (nat64)"},
+        {"64:ff9b::7f00:1", "loopback (nat64)"},
+        {"64:ff9b::a9fe:a9fe", "link-local (nat64)"},
+        {"64:ff9b::5db8:d822", ""},
+    }
+    for _, tt := range tests {
+        if got := blockedClass(netip.MustParseAddr(tt.addr)); got != tt.want {
+            t.Errorf("blockedClass(%s) = %q, want %q", tt.addr, got, tt.want)
+        }
+    }
+}
+
+func TestResolve(t *testing.T) {
+    fakeLookup(t, map[string][]string{
+        "public.example.com":  {"93.184.216.34"},
+        "rebind.example.com":  {"93.184.216.34", "127.0.0.1"},
+        "metadata.example":    {"169.254.169.254"},
+        "mapped.example.com":  {"::ffff:10.0.0.1"},
+        "office.example.com":  {"10.20.1.5"},
+    })
+
+    g := New()
+    g.Configure(config.DestinationGuardConfig{Enabled: true, Allow: []string{"10.20.0.0/16", "192.168.1.10"}})
+
+    tests := []struct {
+        host    string
+        want    string
+        blocked bool
+        failed  bool
+    }{
+        {host: "public.example.com", want: "93.184.216.34:443"},
+        {host: "93.184.216.34", want: "93.184.216.34:443"},
+        {host: "[2606:2800:220:1::1]", want: "[2606:2800:220:1::1]:443"},
+        {host: "rebind.example.com", blocked: true},
+        {host: "metadata.example", blocked: true},
+        {host: "mapped.example.com", blocked: true},
+        {host: "127.0.0.1", blocked: true},
+        {host: "::ffff:127.0.0.1", blocked: true},
+        {host: "[::1]", blocked: true},
+        {host: "office.example.com", want: "10.20.1.5:443"},
+        {host: "192.168.1.10", want: "192.168.1.10:443"},
+        {host: "192.168.1.11", blocked: true},
+        {host: "unknown.example.com", failed: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.host, func(t *testing.T) {
+            got, err := g.Resolve(context.Background(), tt.host, 443)
+            var blocked *BlockedError
+            switch {
+            case tt.blocked:
+                if !errors.As(err, &blocked) {
+                    t.Fatalf("Resolve() = %q, %v, want *BlockedError", got, err)
+                }
+            case tt.failed:
+                if err == nil || errors.As(err, &blocked) {
+                    t.Fatalf("Resolve() = %q, %v, want lookup error", got, err)
+                }
+            default:
+                if err != nil || got != tt.want {
+                    t.Fatalf("Resolve() = %q, %v, want %q", got, err, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestResolveDisabledKeepsHost(t *testing.T) {
+    fakeLookup(t, nil)
+
+    g := New()
+    got, err := g.Resolve(context.Background(), "localhost", 80)
+    if err != nil || got != "localhost:80" {
+        t.Fatalf("Resolve() = %q, %v, want localhost:80 unchecked", got, err)
+    }
+}
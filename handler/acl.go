@@ -0,0 +1,52 @@
+package handler
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "proxy-server/utils"
+    "strconv"
+
+    "go.uber.org/zap"
+)
+
+// checkDestination kiểm tra ACL cho đích host:port của user, trả về lỗi *acl.DeniedError khi bị từ chối
+func (h *ProxyHandler) checkDestination(ctx context.Context, username, method, address string, defaultPort int) error {
+    if h.options.ACL == nil {
+        return nil
+    }
+
+    host, portStr, err := net.SplitHostPort(address)
+    port, _ := strconv.Atoi(portStr)
+    if err != nil {
+        host, port = address, defaultPort
+    }
+
+    account := ""
+    if username != "" {
+        account = h.authenticator.Account(username)
+    }
+    err = h.options.ACL.Check(ctx, account, method, host, port)
+    if err != nil {
+        utils.GetLogger().Warn("Destination denied by ACL",
+            zap.String("user", username),
+            zap.String("method", method),
+            zap.String("destination", address),
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.String("reason", err.Error()),
+        )
+    }
+    return err
+}
+
+// requestDestination trả về host[:port] của request và port mặc định theo scheme
+func requestDestination(r *http.Request) (string, int) {
+    address := r.URL.Host
+    if address == "" {
+        address = r.Host
+    }
+    if r.Method == http.MethodConnect || r.URL.Scheme == "https" {
+        return address, 443
+    }
+    return address, 80
+}
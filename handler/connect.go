@@ -0,0 +1,57 @@
+package handler
+
+import (
+    "fmt"
+    "net"
+    "proxy-server/config"
+    "proxy-server/metrics"
+    "proxy-server/utils"
+    "slices"
+    "strconv"
+
+    "go.uber.org/zap"
+)
+
+// connectPorts là port CONNECT được phép của listener và của các tài khoản có ghi đè
+type connectPorts struct {
+    listener []config.PortRange
+    users    map[string][]config.PortRange
+}
+
+// newConnectPorts đọc port CONNECT từ cấu hình listener, cấu hình đã được kiểm tra trong Config.Check
+func newConnectPorts(cfg *config.ProxyConfig) connectPorts {
+    ports := connectPorts{users: make(map[string][]config.PortRange)}
+    ports.listener, _ = config.ParsePortRanges(cfg.AllowedConnectPorts())
+    for _, u := range cfg.Users {
+        if len(u.ConnectPorts) > 0 {
+            ports.users[u.Username], _ = config.ParsePortRanges(u.ConnectPorts)
+        }
+    }
+    return ports
+}
+
+// checkConnectPort kiểm tra port đích của CONNECT với danh sách của tài khoản, không có thì của listener
+func (h *ProxyHandler) checkConnectPort(username, address string) error {
+    port := 443
+    if _, portStr, err := net.SplitHostPort(address); err == nil {
+        port, _ = strconv.Atoi(portStr)
+    }
+
+    allowed := h.connectPorts.listener
+    if username != "" {
+        if ports, ok := h.connectPorts.users[h.authenticator.Account(username)]; ok {
+            allowed = ports
+        }
+    }
+    if slices.ContainsFunc(allowed, func(r config.PortRange) bool { return r.Contains(port) }) {
+        return nil
+    }
+
+    metrics.ConnectRejected.WithLabelValues(metrics.Port(h.config.ServerPort)).Inc()
+    utils.GetLogger().Warn("CONNECT port not allowed",
+        zap.String("user", username),
+        zap.String("destination", address),
+        zap.Int("proxy_port", h.config.ServerPort),
+    )
+    return fmt.Errorf("CONNECT to port %d is not allowed", port)
+}
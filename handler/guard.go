@@ -0,0 +1,61 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "proxy-server/guard"
+    "proxy-server/upstream"
+    "proxy-server/utils"
+    "strconv"
+    "strings"
+
+    "go.uber.org/zap"
+)
+
+// guardDestination kiểm tra đích host[:port] với destination guard và trả về ctx mang địa chỉ
+// đã kiểm tra, upstream socks5 dial tới địa chỉ đó để DNS rebinding không đổi được đích.
+// Đích nội bộ trả về lỗi *guard.BlockedError
+func (h *ProxyHandler) guardDestination(ctx context.Context, username, address string, defaultPort int) (context.Context, error) {
+    if h.options.Guard == nil {
+        return ctx, nil
+    }
+
+    host, portStr, err := net.SplitHostPort(address)
+    port, _ := strconv.Atoi(portStr)
+    if err != nil {
+        host, port = address, defaultPort
+    }
+
+    resolved, err := h.options.Guard.Resolve(ctx, host, port)
+    if err != nil {
+        var blocked *guard.BlockedError
+        message := "Failed to resolve destination"
+        if errors.As(err, &blocked) {
+            message = "Destination blocked by guard"
+        }
+        utils.GetLogger().Warn(message,
+            zap.String("user", username),
+            zap.String("destination", address),
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.String("reason", err.Error()),
+        )
+        return ctx, err
+    }
+
+    ip, _, _ := net.SplitHostPort(resolved)
+    return upstream.WithPinnedHost(ctx, strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ip), nil
+}
+
+// rejectGuarded trả 403 cho đích bị destination guard chặn, 502 khi không phân giải được tên miền
+func (h *ProxyHandler) rejectGuarded(w http.ResponseWriter, r *http.Request, err error) {
+    var blocked *guard.BlockedError
+    if errors.As(err, &blocked) {
+        h.recordRequest(nil, r.Method, http.StatusForbidden)
+        http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+        return
+    }
+    h.recordRequest(nil, r.Method, http.StatusBadGateway)
+    http.Error(w, "Cannot resolve destination: "+err.Error(), http.StatusBadGateway)
+}
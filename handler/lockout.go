@@ -0,0 +1,78 @@
+package handler
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "proxy-server/utils"
+    "strconv"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// banned kiểm tra IP nguồn và tài khoản (rỗng = chưa xác thực) có đang bị cấm không
+func (h *ProxyHandler) banned(ip, username string) (time.Duration, bool) {
+    if h.options.Lockout == nil {
+        return 0, false
+    }
+    return h.options.Lockout.Banned(ip, h.lockoutAccount(username))
+}
+
+// lockoutAccount trả về tài khoản dùng để đếm lần sai. Username không tồn tại chỉ được
+// đếm theo IP, để tên bịa ra không làm phình bộ đếm
+func (h *ProxyHandler) lockoutAccount(username string) string {
+    if username == "" || !h.authenticator.Exists(username) {
+        return ""
+    }
+    return h.authenticator.Account(username)
+}
+
+// authFailed ghi nhận lần xác thực sai và trả lời chậm lại theo số lần sai, dừng chờ khi client ngắt kết nối
+func (h *ProxyHandler) authFailed(ctx context.Context, ip, username string) {
+    if h.options.Lockout == nil {
+        return
+    }
+    delay := h.options.Lockout.Fail(ip, h.lockoutAccount(username))
+    if delay <= 0 {
+        return
+    }
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+    case <-ctx.Done():
+    }
+}
+
+// authSucceeded xóa các lần sai của tài khoản
+func (h *ProxyHandler) authSucceeded(username string) {
+    if h.options.Lockout != nil && username != "" {
+        h.options.Lockout.Succeed(h.authenticator.Account(username))
+    }
+}
+
+// rejectBanned trả 429 cho client bị cấm, Retry-After là thời gian còn lại của lệnh cấm
+func (h *ProxyHandler) rejectBanned(w http.ResponseWriter, r *http.Request, ip, username string, remaining time.Duration) {
+    utils.GetLogger().Warn("Rejected banned client",
+        zap.String("client_ip", ip),
+        zap.String("user", username),
+        zap.Int("proxy_port", h.config.ServerPort),
+        zap.Duration("remaining", remaining),
+    )
+
+    seconds := int64((remaining + time.Second - 1) / time.Second)
+    w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+    h.recordRequest(nil, r.Method, http.StatusTooManyRequests)
+    http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+}
+
+// clientIP trả về IP trong địa chỉ host:port của client
+func clientIP(remoteAddr string) string {
+    host, _, err := net.SplitHostPort(remoteAddr)
+    if err != nil {
+        return remoteAddr
+    }
+    return host
+}
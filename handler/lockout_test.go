@@ -0,0 +1,44 @@
+package handler
+
+import (
+    "context"
+    "proxy-server/config"
+    "proxy-server/lockout"
+    "testing"
+    "time"
+)
+
+func TestAuthFailedCountsOnlyKnownAccounts(t *testing.T) {
+    l := lockout.New()
+    l.Configure(config.LockoutConfig{
+        Enabled:     true,
+        MaxFailures: 2,
+        Window:      time.Minute,
+        BanDuration: time.Minute,
+        MaxBan:      time.Minute,
+    })
+    h := NewProxyHandler(&config.ProxyConfig{
+        Name:        "gateway",
+        Mode:        config.ModeGateway,
+        RequireAuth: true,
+        Users:       []config.UserConfig{{Username: "alice", Password: "secret"}},
+    }, nil, Options{Lockout: l})
+
+    h.authFailed(context.Background(), "198.51.100.1", "mallory")
+    h.authFailed(context.Background(), "198.51.100.2", "mallory")
+    h.authFailed(context.Background(), "198.51.100.3", "alice-pool-us")
+    h.authFailed(context.Background(), "198.51.100.4", "alice")
+
+    var users []string
+    for _, ban := range l.Bans() {
+        if ban.Kind == lockout.KindUser {
+            users = append(users, ban.Key)
+        }
+    }
+    if len(users) != 1 || users[0] != "alice" {
+        t.Errorf("banned users = %v, want only alice", users)
+    }
+    if _, banned := h.banned("203.0.113.1", "mallory"); banned {
+        t.Error("unknown username banned, failures of invented names must only count per IP")
+    }
+}
@@ -1,122 +1,327 @@
 package handler
 
 import (
-    "crypto/tls"
+    "context"
+    "errors"
     "fmt"
     "io"
     "net"
     "net/http"
-    "net/url"
+    "proxy-server/accounting"
+    "proxy-server/acl"
     "proxy-server/auth"
     "proxy-server/config"
+    "proxy-server/guard"
+    "proxy-server/lockout"
+    "proxy-server/metrics"
+    "proxy-server/quota"
+    "proxy-server/throttle"
+    "proxy-server/upstream"
     "proxy-server/utils"
+    "strconv"
     "strings"
+    "sync"
     "time"
 
     "go.uber.org/zap"
 )
 
+// Options gom các cấu hình dùng chung cho handler của mọi listener
+type Options struct {
+    Retry       config.RetryConfig
+    // DialTimeout giới hạn thời gian mở tunnel qua một upstream
+    DialTimeout time.Duration
+    // Tunnels theo dõi tunnel đang mở, nil = mỗi handler dùng bộ theo dõi riêng
+    Tunnels     *Tunnels
+    // Usage ghi nhận lưu lượng theo tài khoản, nil = tắt thống kê
+    Usage       *accounting.Ledger
+    // Quotas kiểm tra giới hạn của tài khoản trước khi proxy, nil = không giới hạn
+    Quotas      *quota.Tracker
+    // Throttle giới hạn băng thông của body HTTP và tunnel, nil = không giới hạn
+    Throttle    *throttle.Throttle
+    // Lockout chống dò mật khẩu theo IP nguồn và tài khoản, nil = tắt
+    Lockout     *lockout.Lockout
+    // ACL kiểm tra đích đến theo tài khoản, nil = không giới hạn
+    ACL         *acl.ACL
+    // Guard chặn đích đến là địa chỉ nội bộ, nil = không chặn
+    Guard       *guard.Guard
+}
+
 type ProxyHandler struct {
     config        *config.ProxyConfig // Thay đổi từ Config sang ProxyConfig
-    client        *http.Client
+    registry      *upstream.Registry
+    options       Options
     authenticator *auth.ProxyAuthenticator
+    connectPorts  connectPorts
 }
 
-func NewProxyHandler(cfg *config.ProxyConfig) *ProxyHandler {
-    proxyURL, err := url.Parse(cfg.ProxyURL)
-    if err != nil {
-        utils.GetLogger().Fatal("Failed to parse proxy URL", zap.Error(err))
-    }
-    
-    transport := &http.Transport{
-        Proxy: http.ProxyURL(proxyURL),
-        DialContext: (&net.Dialer{
-            Timeout:   30 * time.Second,
-            KeepAlive: 30 * time.Second,
-        }).DialContext,
-        MaxIdleConns:          100,
-        IdleConnTimeout:       90 * time.Second,
-        TLSHandshakeTimeout:   15 * time.Second,
-        ExpectContinueTimeout: 2 * time.Second,
-        TLSClientConfig: &tls.Config{
-            InsecureSkipVerify: false,
-            MinVersion:         tls.VersionTLS12,
-        },
-    }
-    
-    client := &http.Client{
-        Transport: transport,
-        Timeout:   120 * time.Second,
-        CheckRedirect: func(req *http.Request, via []*http.Request) error {
-            if len(via) >= 10 {
-                return fmt.Errorf("too many redirects")
-            }
-            return nil
-        },
+func NewProxyHandler(cfg *config.ProxyConfig, registry *upstream.Registry, options Options) *ProxyHandler {
+    if options.Tunnels == nil {
+        options.Tunnels = NewTunnels()
     }
-    
     return &ProxyHandler{
         config:        cfg,
-        client:        client,
+        registry:      registry,
+        options:       options,
         authenticator: auth.NewProxyAuthenticator(cfg),
+        connectPorts:  newConnectPorts(cfg),
     }
 }
 
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-    // Kiểm tra authentication trước
-    if !h.authenticator.Authenticate(r) {
-        h.authenticator.RequireAuth(w)
+    // Quy tắc IP nguồn của listener được kiểm tra trước mật khẩu
+    ip := clientIP(r.RemoteAddr)
+    access := h.authenticator.CheckAddress(ip)
+    if access == auth.AccessDenied {
+        h.rejectAddress(w, r, ip)
+        return
+    }
+    
+    // IP được cho qua không cần mật khẩu dùng listener như client không xác thực
+    var username string
+    if access == auth.AccessAuth {
+        // IP bị cấm bị từ chối trước khi kiểm tra mật khẩu
+        if remaining, banned := h.banned(ip, ""); banned {
+            h.rejectBanned(w, r, ip, "", remaining)
+            return
+        }
+        
+        var ok bool
+        username, ok = h.authenticator.Authenticate(r)
+        if !ok {
+            // Client chưa gửi credentials (lần đầu nhận 407) không bị tính là sai
+            if username != "" {
+                h.authFailed(r.Context(), ip, username)
+            }
+            h.recordRequest(nil, r.Method, http.StatusProxyAuthRequired)
+            h.authenticator.RequireAuth(w)
+            return
+        }
+        if remaining, banned := h.banned(ip, username); banned {
+            h.rejectBanned(w, r, ip, username, remaining)
+            return
+        }
+        h.authSucceeded(username)
+    }
+    
+    // CONNECT chỉ được tới các port cho phép của listener hoặc của tài khoản
+    address, defaultPort := requestDestination(r)
+    if r.Method == http.MethodConnect {
+        if err := h.checkConnectPort(username, address); err != nil {
+            h.recordRequest(nil, r.Method, http.StatusForbidden)
+            http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+            return
+        }
+    }
+    
+    // Đích đến bị ACL từ chối trả 403 kèm lý do
+    if err := h.checkDestination(r.Context(), username, r.Method, address, defaultPort); err != nil {
+        h.recordRequest(nil, r.Method, http.StatusForbidden)
+        http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+        return
+    }
+    
+    // Đích là địa chỉ nội bộ bị chặn, upstream socks5 kết nối tới đúng địa chỉ đã kiểm tra
+    ctx, err := h.guardDestination(r.Context(), username, address, defaultPort)
+    if err != nil {
+        h.rejectGuarded(w, r, err)
+        return
+    }
+    r = r.WithContext(ctx)
+    
+    // Kiểm tra quota trước khi chọn upstream, chỗ tunnel được trả lại khi tunnel CONNECT đóng
+    release, err := h.beginQuota(username, r.Method == http.MethodConnect)
+    if err != nil {
+        h.rejectQuota(w, r, username, err)
+        return
+    }
+    defer release()
+    
+    var up *upstream.Upstream
+    sel, err := h.selector(username, h.sessionFromHeader(r))
+    if err == nil {
+        up, err = h.registry.Select(sel)
+    }
+    if err != nil {
+        utils.GetLogger().Warn("Failed to select upstream",
+            zap.String("user", username),
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.Error(err),
+        )
+        h.recordRequest(nil, r.Method, upstreamErrorStatus(err))
+        http.Error(w, "Failed to select upstream: "+err.Error(), upstreamErrorStatus(err))
         return
     }
     
     if r.Method == "CONNECT" {
-        h.handleHTTPS(w, r)
+        h.handleHTTPS(w, r, username, sel, up)
         return
     }
     
-    h.handleHTTP(w, r)
+    h.handleHTTP(w, r, username, sel, up)
+}
+
+// rejectAddress trả 403 cho client bị chặn bởi quy tắc IP của listener
+func (h *ProxyHandler) rejectAddress(w http.ResponseWriter, r *http.Request, ip string) {
+    utils.GetLogger().Warn("Rejected client by IP rules",
+        zap.String("client_ip", ip),
+        zap.Int("proxy_port", h.config.ServerPort),
+    )
+    h.recordRequest(nil, r.Method, http.StatusForbidden)
+    http.Error(w, "Access denied", http.StatusForbidden)
+}
+
+// beginQuota kiểm tra quota của tài khoản, tunnel = true giữ một chỗ tunnel tới khi gọi release
+func (h *ProxyHandler) beginQuota(username string, tunnel bool) (func(), error) {
+    if h.options.Quotas == nil {
+        return func() {}, nil
+    }
+    return h.options.Quotas.Begin(h.authenticator.Account(username), tunnel)
+}
+
+// rejectQuota trả 429 kèm lý do, Retry-After cho biết khi nào giới hạn được đặt lại
+func (h *ProxyHandler) rejectQuota(w http.ResponseWriter, r *http.Request, username string, err error) {
+    utils.GetLogger().Warn("Quota exceeded",
+        zap.String("user", username),
+        zap.Int("proxy_port", h.config.ServerPort),
+        zap.Error(err),
+    )
+    
+    var exceeded *quota.ExceededError
+    if errors.As(err, &exceeded) && exceeded.RetryAfter > 0 {
+        seconds := int64((exceeded.RetryAfter + time.Second - 1) / time.Second)
+        w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+    }
+    h.recordRequest(nil, r.Method, http.StatusTooManyRequests)
+    http.Error(w, "Quota exceeded: "+err.Error(), http.StatusTooManyRequests)
+}
+
+// recordRequest ghi nhận request vào metric, up = nil khi chưa chọn được upstream
+func (h *ProxyHandler) recordRequest(up *upstream.Upstream, method string, status int) {
+    upstreamName := ""
+    if up != nil {
+        upstreamName = up.Name
+    }
+    metrics.Requests.WithLabelValues(metrics.Port(h.config.ServerPort), upstreamName, metrics.Method(method), metrics.Status(status)).Inc()
+}
+
+// selector xác định cách chọn upstream cho client: gateway chọn theo username,
+// pool chọn theo strategy của listener, per-port dùng upstream cố định.
+// session (từ username hoặc header) giữ nguyên upstream cho gateway và pool,
+// chỉ trong phạm vi listener và tài khoản của client
+func (h *ProxyHandler) selector(username, session string) (upstream.Selector, error) {
+    if h.config.Mode != config.ModeGateway && h.config.Mode != config.ModePool {
+        return upstream.Selector{Port: h.config.ServerPort}, nil
+    }
+    
+    sel, err := upstream.ParseSelector(h.authenticator.Selector(username))
+    if err != nil {
+        return sel, err
+    }
+    
+    if h.config.Mode == config.ModePool {
+        // Listener pool chỉ nhận session trong username, upstream do strategy chọn
+        if sel.Port != 0 || sel.Pool != "" {
+            return sel, fmt.Errorf("%w: pool listener only accepts session", upstream.ErrInvalidSelector)
+        }
+        sel = upstream.Selector{
+            Pool:     h.config.PoolName,
+            Strategy: h.config.Strategy,
+            Session:  sel.Session,
+        }
+    }
+    
+    if sel.Session == "" {
+        sel.Session = session
+    }
+    sel.Listener = h.config.Name
+    sel.Account = h.authenticator.Account(username)
+    return sel, nil
 }
 
-func (h *ProxyHandler) handleHTTP(w http.ResponseWriter, r *http.Request) {
+// sessionFromHeader đọc session token từ header cấu hình cho listener
+func (h *ProxyHandler) sessionFromHeader(r *http.Request) string {
+    if h.config.SessionHeader == "" {
+        return ""
+    }
+    return strings.TrimSpace(r.Header.Get(h.config.SessionHeader))
+}
+
+// upstreamErrorStatus chuyển lỗi chọn upstream thành HTTP status
+func upstreamErrorStatus(err error) int {
+    switch {
+    case errors.Is(err, upstream.ErrInvalidSelector),
+        errors.Is(err, upstream.ErrUnknownUpstream),
+        errors.Is(err, upstream.ErrUnknownPool):
+        return http.StatusBadRequest
+    case errors.Is(err, upstream.ErrNoUpstream),
+        errors.Is(err, upstream.ErrCircuitOpen):
+        return http.StatusServiceUnavailable
+    }
+    return http.StatusBadGateway
+}
+
+func (h *ProxyHandler) handleHTTP(w http.ResponseWriter, r *http.Request, username string, sel upstream.Selector, up *upstream.Upstream) {
     logger := utils.GetLogger().With(
         zap.String("method", r.Method),
-        zap.String("url", r.URL.String()),
+        zap.String("url", utils.RedactURL(r.URL.String())),
     )
     
-    logger.Info("Processing HTTP request")
+    logger.Info("Processing HTTP request", zap.String("upstream", up.Name))
     
     // Xây dựng target URL
     targetURL := h.buildTargetURL(r)
     if targetURL == "" {
+        h.recordRequest(up, r.Method, http.StatusBadRequest)
         http.Error(w, "Cannot determine target URL", http.StatusBadRequest)
         return
     }
     
-    // Tạo request mới
-    proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
-    if err != nil {
-        logger.Error("Failed to create proxy request", zap.Error(err))
-        http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
-        return
+    // Lưu lượng được tính vào quota ngay khi truyền, hết quota thì request bị hủy
+    ctx, cancel := context.WithCancelCause(r.Context())
+    defer cancel(nil)
+    r = r.WithContext(ctx)
+    charge := func(n int64) error {
+        err := h.chargeQuota(username, n)
+        if err != nil {
+            cancel(err)
+        }
+        return err
     }
     
-    // Copy headers
-    h.copyRequestHeaders(r, proxyReq)
-    
-    // Thêm headers quan trọng
-    h.addRequiredHeaders(proxyReq)
+    // Đếm body client gửi lên, kể cả phần đã gửi trước khi request bị lỗi
+    limiters := h.limiters(username)
+    requestBody := &countingReadCloser{ReadCloser: throttle.ReadCloser(r.Context(), r.Body, throttle.Upload, limiters...), charge: charge}
+    if hasBody(r) {
+        r.Body = requestBody
+    }
     
     start := time.Now()
-    resp, err := h.client.Do(proxyReq)
+    resp, up, err := h.doWithRetry(r, targetURL, sel, up, logger)
+    logger = logger.With(zap.String("upstream", up.Name))
+    port := metrics.Port(h.config.ServerPort)
+    defer func() {
+        metrics.Bytes.WithLabelValues(port, up.Name, "in").Add(float64(requestBody.n.Load()))
+    }()
+    var exceeded *quota.ExceededError
+    if err != nil && errors.As(context.Cause(ctx), &exceeded) {
+        h.rejectQuota(w, r, username, exceeded)
+        return
+    }
     if err != nil {
         logger.Error("Failed to send request through proxy", 
             zap.Error(err),
-            zap.String("target", targetURL),
+            zap.String("target", utils.RedactURL(targetURL)),
         )
-        http.Error(w, "Failed to connect through proxy: "+err.Error(), http.StatusBadGateway)
+        h.recordRequest(up, r.Method, upstreamErrorStatus(err))
+        http.Error(w, "Failed to connect through proxy: "+err.Error(), upstreamErrorStatus(err))
         return
     }
     defer resp.Body.Close()
+    h.recordRequest(up, r.Method, resp.StatusCode)
+    
+    done := up.Track()
+    defer done()
     
     duration := time.Since(start)
     
@@ -133,7 +338,15 @@ func (h *ProxyHandler) handleHTTP(w http.ResponseWriter, r *http.Request) {
     w.WriteHeader(resp.StatusCode)
     
     // Copy response body
-    written, err := io.Copy(w, resp.Body)
+    responseBody := &countingReadCloser{ReadCloser: resp.Body, charge: charge}
+    written, err := io.Copy(w, throttle.Reader(r.Context(), responseBody, throttle.Download, limiters...))
+    metrics.Bytes.WithLabelValues(port, up.Name, "out").Add(float64(written))
+    h.recordUsage(username, up, accounting.Usage{Requests: 1, BytesIn: requestBody.n.Load(), BytesOut: written})
+    if errors.As(err, &exceeded) {
+        // Header đã gửi, hủy kết nối để client không nhận response bị cắt như response đầy đủ
+        logger.Warn("Quota exceeded, aborting response", zap.String("user", username), zap.Error(err))
+        panic(http.ErrAbortHandler)
+    }
     if err != nil {
         logger.Error("Failed to copy response body", zap.Error(err))
     } else {
@@ -143,26 +356,36 @@ func (h *ProxyHandler) handleHTTP(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-func (h *ProxyHandler) handleHTTPS(w http.ResponseWriter, r *http.Request) {
+func (h *ProxyHandler) handleHTTPS(w http.ResponseWriter, r *http.Request, username string, sel upstream.Selector, up *upstream.Upstream) {
     logger := utils.GetLogger().With(
         zap.String("method", r.Method),
-        zap.String("url", r.URL.String()),
+        zap.String("url", utils.RedactURL(r.URL.String())),
     )
     
-    logger.Info("Processing HTTPS CONNECT request")
+    logger.Info("Processing HTTPS CONNECT request", zap.String("upstream", up.Name))
     
-    // Kết nối trực tiếp đến destination
-    destConn, err := net.DialTimeout("tcp", r.URL.Host, 30*time.Second)
+    // Mở tunnel tới destination thông qua upstream proxy
+    destConn, up, err := h.dialWithRetry(r.Context(), r.URL.Host, sel, up, logger)
+    logger = logger.With(zap.String("upstream", up.Name))
     if err != nil {
-        logger.Error("Failed to connect to destination", zap.Error(err))
-        http.Error(w, "Failed to connect to destination", http.StatusBadGateway)
+        var statusErr *upstream.StatusError
+        if errors.As(err, &statusErr) {
+            logger.Error("Upstream proxy rejected CONNECT",
+                zap.Int("upstream_status", statusErr.StatusCode),
+                zap.String("proxy_address", up.Address()),
+            )
+            h.recordRequest(up, r.Method, statusErr.StatusCode)
+            http.Error(w, "Upstream proxy rejected CONNECT: "+statusErr.Status, statusErr.StatusCode)
+            return
+        }
+        
+        logger.Error("Failed to connect through upstream proxy", zap.Error(err))
+        h.recordRequest(up, r.Method, upstreamErrorStatus(err))
+        http.Error(w, "Failed to connect through proxy", upstreamErrorStatus(err))
         return
     }
     defer destConn.Close()
     
-    // Trả về 200 OK
-    w.WriteHeader(http.StatusOK)
-    
     // Hijack connection
     hijacker, ok := w.(http.Hijacker)
     if !ok {
@@ -171,7 +394,7 @@ func (h *ProxyHandler) handleHTTPS(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    clientConn, _, err := hijacker.Hijack()
+    clientConn, clientBuf, err := hijacker.Hijack()
     if err != nil {
         logger.Error("Failed to hijack connection", zap.Error(err))
         http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
@@ -179,18 +402,92 @@ func (h *ProxyHandler) handleHTTPS(w http.ResponseWriter, r *http.Request) {
     }
     defer clientConn.Close()
     
-    logger.Info("HTTPS tunnel established")
+    // Server timeouts vẫn còn trên connection đã hijack, tunnel không được bị cắt theo chúng
+    clientConn.SetDeadline(time.Time{})
+    
+    // Trả về 200 cho client khi tunnel qua upstream đã sẵn sàng
+    h.recordRequest(up, r.Method, http.StatusOK)
+    if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+        logger.Error("Failed to write CONNECT response", zap.Error(err))
+        return
+    }
+    
+    // Client có thể đã gửi dữ liệu (ví dụ TLS ClientHello) trước khi hijack
+    if n := clientBuf.Reader.Buffered(); n > 0 {
+        buffered, _ := clientBuf.Reader.Peek(n)
+        if _, err := destConn.Write(buffered); err != nil {
+            logger.Error("Failed to forward buffered client data", zap.Error(err))
+            return
+        }
+    }
+    
+    logger.Info("HTTPS tunnel established",
+        zap.String("proxy_address", up.Address()),
+    )
     
-    // Thiết lập tunnel
-    go h.copyData(destConn, clientConn)
-    h.copyData(clientConn, destConn)
+    h.tunnel("connect", username, r.URL.Host, up, clientConn, destConn)
 }
 
-func (h *ProxyHandler) copyData(dst, src net.Conn) {
-    defer dst.Close()
-    defer src.Close()
+// tunnel chuyển dữ liệu hai chiều giữa client và destination, dùng chung cho CONNECT và SOCKS5.
+// Tunnel được đăng ký trong options.Tunnels để có thể liệt kê và đóng từ admin API
+func (h *ProxyHandler) tunnel(protocol, username, destination string, up *upstream.Upstream, clientConn, destConn net.Conn) {
+    h.recordUsage(username, up, accounting.Usage{Requests: 1})
+    var exceeded sync.Once
+    record := func(in, out int64) error {
+        h.recordUsage(username, up, accounting.Usage{BytesIn: in, BytesOut: out})
+        err := h.chargeQuota(username, in+out)
+        if err != nil {
+            exceeded.Do(func() {
+                utils.GetLogger().Warn("Quota exceeded, closing tunnel",
+                    zap.String("user", username),
+                    zap.String("destination", destination),
+                    zap.Int("proxy_port", h.config.ServerPort),
+                    zap.Error(err),
+                )
+            })
+        }
+        return err
+    }
     
-    io.Copy(dst, src)
+    h.options.Tunnels.run(record, h.limiters(username), TunnelInfo{
+        Listener:    h.config.Name,
+        Port:        h.config.ServerPort,
+        Protocol:    protocol,
+        User:        username,
+        Client:      clientConn.RemoteAddr().String(),
+        Destination: destination,
+        Upstream:    up.Name,
+        Started:     time.Now(),
+    }, clientConn, destConn)
+}
+
+// limiters trả về các giới hạn băng thông áp dụng cho tài khoản trên listener này
+func (h *ProxyHandler) limiters(username string) []*throttle.Limiter {
+    if h.options.Throttle == nil {
+        return nil
+    }
+    return h.options.Throttle.Limiters(h.config.Name, h.authenticator.Account(username))
+}
+
+// recordUsage ghi nhận lưu lượng của tài khoản vào thống kê (nếu được bật)
+func (h *ProxyHandler) recordUsage(username string, up *upstream.Upstream, usage accounting.Usage) {
+    account := h.authenticator.Account(username)
+    if h.options.Usage != nil {
+        h.options.Usage.Add(accounting.Key{
+            User:     account,
+            Port:     h.config.ServerPort,
+            Upstream: up.Name,
+        }, usage)
+    }
+}
+
+// chargeQuota tính bytes vào quota lưu lượng của tài khoản ngay khi truyền,
+// trả về *quota.ExceededError khi tài khoản vừa hết quota
+func (h *ProxyHandler) chargeQuota(username string, bytes int64) error {
+    if h.options.Quotas == nil {
+        return nil
+    }
+    return h.options.Quotas.Add(h.authenticator.Account(username), bytes)
 }
 
 func (h *ProxyHandler) buildTargetURL(r *http.Request) string {
@@ -236,8 +533,10 @@ func (h *ProxyHandler) copyRequestHeaders(src, dst *http.Request) {
     for name, values := range src.Header {
         // Skip problematic headers
         if strings.EqualFold(name, "Proxy-Connection") || 
+           strings.EqualFold(name, "Proxy-Authorization") ||
            strings.EqualFold(name, "Connection") ||
-           strings.EqualFold(name, "Keep-Alive") {
+           strings.EqualFold(name, "Keep-Alive") ||
+           (h.config.SessionHeader != "" && strings.EqualFold(name, h.config.SessionHeader)) {
             continue
         }
         
@@ -0,0 +1,140 @@
+package handler
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "proxy-server/acl"
+    "proxy-server/config"
+    "proxy-server/guard"
+    "proxy-server/quota"
+    "proxy-server/upstream"
+    "strings"
+    "testing"
+    "time"
+)
+
+// newTestHandler tạo listener single có ACL, guard và quota cho alice/secret,
+// upstream là HTTP proxy giả trả 200 với body
+func newTestHandler(t *testing.T, limits config.QuotaLimits, body string) *ProxyHandler {
+    t.Helper()
+    proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        io.WriteString(w, body)
+    }))
+    t.Cleanup(proxy.Close)
+    up, err := config.ParseUpstream(proxy.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    up.Name = "up"
+
+    listener := config.ProxyConfig{
+        Name:        "port-3000",
+        ServerPort:  3000,
+        Upstream:    "up",
+        RequireAuth: true,
+        Users: []config.UserConfig{
+            {Username: "alice", Password: "secret", Quota: limits},
+        },
+    }
+    registry, err := upstream.NewRegistry(&config.Config{
+        Upstreams: []config.UpstreamConfig{up},
+        Listeners: []config.ProxyConfig{listener},
+        Timeouts:  config.TimeoutConfig{Dial: time.Second, Request: 5 * time.Second},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    a := acl.New()
+    a.Configure([]config.ACLRule{{Name: "no-lab", Action: config.ACLDeny, CIDRs: []string{"10.9.0.0/16"}}}, nil)
+    g := guard.New()
+    g.Configure(config.DestinationGuardConfig{Enabled: true})
+    quotas, err := quota.Open(config.QuotaConfig{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    quotas.SetLimits(map[string]config.QuotaLimits{"alice": listener.Users[0].Quota})
+
+    return NewProxyHandler(&listener, registry, Options{
+        Retry:       config.RetryConfig{MaxAttempts: 1},
+        DialTimeout: time.Second,
+        ACL:         a,
+        Guard:       g,
+        Quotas:      quotas,
+    })
+}
+
+// TestServeHTTPCheckOrder kiểm tra thứ tự xác thực, port CONNECT, ACL, guard rồi quota.
+// Request bị từ chối trước quota không được tính vào giới hạn request theo phút
+func TestServeHTTPCheckOrder(t *testing.T) {
+    h := newTestHandler(t, config.QuotaLimits{RequestsPerMinute: 1}, "ok")
+
+    steps := []struct {
+        name   string
+        method string
+        target string
+        auth   bool
+        status int
+        body   string
+    }{
+        {"no credentials", http.MethodGet, "http://10.9.1.1/", false, http.StatusProxyAuthRequired, ""},
+        {"connect port before acl", http.MethodConnect, "10.9.1.1:25", true, http.StatusForbidden, "port 25"},
+        {"acl before guard", http.MethodGet, "http://10.9.1.1/", true, http.StatusForbidden, "denied by ACL rule no-lab"},
+        {"guard after acl", http.MethodGet, "http://10.1.1.1/", true, http.StatusForbidden, "private address"},
+        {"guard on connect", http.MethodConnect, "[::1]:443", true, http.StatusForbidden, "loopback address"},
+        // Các request bị từ chối ở trên không dùng lượt của requests_per_minute = 1
+        {"allowed", http.MethodGet, "http://93.184.216.34/", true, http.StatusOK, "ok"},
+        {"quota after checks", http.MethodGet, "http://93.184.216.34/", true, http.StatusTooManyRequests, "rate limit of 1 requests per minute"},
+        {"guard before quota", http.MethodGet, "http://10.1.1.1/", true, http.StatusForbidden, "private address"},
+    }
+    for _, tt := range steps {
+        r := httptest.NewRequest(tt.method, tt.target, nil)
+        if tt.method == http.MethodConnect {
+            r.URL.Host, r.Host, r.RequestURI = tt.target, tt.target, tt.target
+        }
+        if tt.auth {
+            r.Header.Set("Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0")
+        }
+        w := httptest.NewRecorder()
+        h.ServeHTTP(w, r)
+
+        if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+            t.Errorf("%s: %s %s = %d %q, want %d containing %q",
+                tt.name, tt.method, tt.target, w.Code, strings.TrimSpace(w.Body.String()), tt.status, tt.body)
+        }
+    }
+}
+
+func TestServeHTTPStopsDownloadAtCap(t *testing.T) {
+    h := newTestHandler(t, config.QuotaLimits{DailyBytes: 64 << 10}, strings.Repeat("x", 1<<20))
+    server := httptest.NewServer(h)
+    defer server.Close()
+
+    proxyURL, _ := url.Parse("http://alice:secret@" + server.Listener.Addr().String())
+    client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+    resp, err := client.Get("http://93.184.216.34/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err == nil {
+        t.Fatalf("download of %d bytes completed, want it aborted at the 64KiB daily quota", len(body))
+    }
+    if len(body) >= 1<<20 {
+        t.Errorf("read %d bytes before the abort", len(body))
+    }
+
+    // Quota đã hết thì request tiếp theo bị từ chối ngay
+    resp, err = client.Get("http://93.184.216.34/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusTooManyRequests {
+        t.Errorf("request after the cap = %d, want 429", resp.StatusCode)
+    }
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+    "context"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "proxy-server/config"
+    "proxy-server/metrics"
+    "proxy-server/upstream"
+    "slices"
+    "sync/atomic"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// dialWithRetry mở kết nối tới addr qua upstream, lỗi do upstream thì thử lại
+// trên upstream khác cùng pool. Trả về upstream cuối cùng đã dùng
+func (h *ProxyHandler) dialWithRetry(ctx context.Context, addr string, sel upstream.Selector, up *upstream.Upstream, logger *zap.Logger) (net.Conn, *upstream.Upstream, error) {
+    var tried []*upstream.Upstream
+    
+    for attempt := 1; ; attempt++ {
+        dialCtx, cancel := context.WithTimeout(ctx, h.options.DialTimeout)
+        start := time.Now()
+        conn, err := up.DialContext(dialCtx, "tcp", addr)
+        cancel()
+        h.observeDial(up, err, time.Since(start))
+        if err == nil {
+            return &trackedConn{Conn: conn, upstream: up, done: up.Track()}, up, nil
+        }
+        
+        // Lỗi do destination (ví dụ upstream trả 502) thì upstream khác cũng sẽ lỗi
+        if attempt >= h.options.Retry.MaxAttempts || !upstream.IsUpstreamFault(err) {
+            return nil, up, err
+        }
+        
+        next, ok := h.nextUpstream(ctx, sel, up, &tried, attempt, err, logger)
+        if !ok {
+            return nil, up, err
+        }
+        up = next
+    }
+}
+
+// observeDial ghi nhận thời gian mở tunnel qua upstream vào metric
+func (h *ProxyHandler) observeDial(up *upstream.Upstream, err error, d time.Duration) {
+    result := "success"
+    if err != nil {
+        result = "error"
+    }
+    metrics.DialDuration.WithLabelValues(metrics.Port(h.config.ServerPort), up.Name, result).Observe(d.Seconds())
+}
+
+// doWithRetry gửi request HTTP qua upstream, thử lại trên upstream khác khi lỗi kết nối.
+// Request có body chỉ được gửi lại nếu body chưa được đọc, để không bao giờ replay
+// request không idempotent đã gửi body
+func (h *ProxyHandler) doWithRetry(r *http.Request, targetURL string, sel upstream.Selector, up *upstream.Upstream, logger *zap.Logger) (*http.Response, *upstream.Upstream, error) {
+    body := &replayGuardBody{ReadCloser: r.Body}
+    var tried []*upstream.Upstream
+    
+    for attempt := 1; ; attempt++ {
+        // Tạo request mới
+        proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, requestBody(r, body))
+        if err != nil {
+            return nil, up, err
+        }
+        proxyReq.ContentLength = r.ContentLength
+        
+        // Copy headers
+        h.copyRequestHeaders(r, proxyReq)
+        
+        // Thêm headers quan trọng
+        h.addRequiredHeaders(proxyReq)
+        
+        if err = up.Allow(); err == nil {
+            start := time.Now()
+            done := up.Track()
+            var resp *http.Response
+            resp, err = up.Client.Do(proxyReq)
+            done()
+            up.Report(err)
+            if err == nil {
+                up.ObserveLatency(time.Since(start))
+                return resp, up, nil
+            }
+        }
+        
+        if attempt >= h.options.Retry.MaxAttempts || r.Context().Err() != nil || !canReplay(r, body) {
+            return nil, up, err
+        }
+        
+        next, ok := h.nextUpstream(r.Context(), sel, up, &tried, attempt, err, logger)
+        if !ok {
+            return nil, up, err
+        }
+        up = next
+    }
+}
+
+// nextUpstream chọn upstream cho lần thử tiếp theo và chờ backoff. Registry trả về
+// upstream đã thử khi không còn lựa chọn khác (selector theo port, pool đã thử hết),
+// khi đó không retry để không gửi lại tới upstream vừa lỗi
+func (h *ProxyHandler) nextUpstream(ctx context.Context, sel upstream.Selector, failed *upstream.Upstream, tried *[]*upstream.Upstream, attempt int, cause error, logger *zap.Logger) (*upstream.Upstream, bool) {
+    *tried = append(*tried, failed)
+    
+    next, err := h.registry.Select(sel, (*tried)...)
+    if err != nil || slices.Contains(*tried, next) {
+        return nil, false
+    }
+    
+    backoff := retryBackoff(h.options.Retry, attempt)
+    logger.Warn("Retrying on another upstream",
+        zap.Int("attempt", attempt+1),
+        zap.String("failed_upstream", failed.Name),
+        zap.String("next_upstream", next.Name),
+        zap.Duration("backoff", backoff),
+        zap.Error(cause),
+    )
+    
+    timer := time.NewTimer(backoff)
+    defer timer.Stop()
+    
+    select {
+    case <-ctx.Done():
+        return nil, false
+    case <-timer.C:
+        return next, true
+    }
+}
+
+// retryBackoff tính thời gian chờ trước lần retry thứ attempt: tăng gấp đôi, có jitter
+func retryBackoff(cfg config.RetryConfig, attempt int) time.Duration {
+    d := cfg.Backoff
+    for i := 1; i < attempt && d < cfg.MaxBackoff; i++ {
+        d *= 2
+    }
+    if cfg.MaxBackoff > 0 && d > cfg.MaxBackoff {
+        d = cfg.MaxBackoff
+    }
+    if d <= 0 {
+        return 0
+    }
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isIdempotent theo RFC 9110 section 9.2.2
+func isIdempotent(method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+        http.MethodPut, http.MethodDelete:
+        return true
+    }
+    return false
+}
+
+// hasBody cho biết request của client có body hay không
+func hasBody(r *http.Request) bool {
+    return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// canReplay: body chưa bị đọc thì gửi lại an toàn với mọi method,
+// request idempotent không có body thì luôn gửi lại được
+func canReplay(r *http.Request, body *replayGuardBody) bool {
+    if !hasBody(r) {
+        return isIdempotent(r.Method)
+    }
+    return !body.started.Load()
+}
+
+func requestBody(r *http.Request, body *replayGuardBody) io.Reader {
+    if !hasBody(r) {
+        return nil
+    }
+    return body
+}
+
+// replayGuardBody ghi nhận body đã bắt đầu được gửi đi hay chưa. Transport đọc body
+// trong goroutine riêng nên cờ là atomic. Close không đóng body của client vì
+// Transport đóng body khi lỗi, body cần còn nguyên cho lần retry
+type replayGuardBody struct {
+    io.ReadCloser
+    started atomic.Bool
+}
+
+func (b *replayGuardBody) Read(p []byte) (int, error) {
+    b.started.Store(true)
+    return b.ReadCloser.Read(p)
+}
+
+func (b *replayGuardBody) Close() error {
+    return nil
+}
+
+// trackedConn giữ upstream ở trạng thái đang dùng cho tới khi tunnel đóng
+type trackedConn struct {
+    net.Conn
+    upstream *upstream.Upstream
+    done     func()
+}
+
+func (c *trackedConn) Close() error {
+    c.done()
+    return c.Conn.Close()
+}
+
+// countingReadCloser đếm số byte body đã đọc, Transport có thể vẫn đang gửi body
+// sau khi trả về response nên bộ đếm là atomic. charge (nếu có) tính byte vào quota,
+// lỗi của charge dừng việc đọc body
+type countingReadCloser struct {
+    io.ReadCloser
+    n      atomic.Int64
+    charge func(n int64) error
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+    n, err := c.ReadCloser.Read(p)
+    c.n.Add(int64(n))
+    if n > 0 && c.charge != nil {
+        if chargeErr := c.charge(int64(n)); chargeErr != nil && err == nil {
+            err = chargeErr
+        }
+    }
+    return n, err
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "net/http/httptest"
+    "os"
+    "proxy-server/config"
+    "proxy-server/upstream"
+    "proxy-server/utils"
+    "strings"
+    "testing"
+
+    "go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+    utils.Logger = zap.NewNop()
+    os.Exit(m.Run())
+}
+
+// newTestRegistry tạo registry gồm pool "pool" hai upstream và listener single ở port 3000
+func newTestRegistry(t *testing.T) *upstream.Registry {
+    t.Helper()
+    registry, err := upstream.NewRegistry(&config.Config{
+        Upstreams: []config.UpstreamConfig{
+            {Name: "a", ProxyScheme: "http", ProxyHost: "127.0.0.1", ProxyPort: 1, Pool: "pool"},
+            {Name: "b", ProxyScheme: "http", ProxyHost: "127.0.0.1", ProxyPort: 2, Pool: "pool"},
+            {Name: "c", ProxyScheme: "http", ProxyHost: "127.0.0.1", ProxyPort: 3},
+        },
+        Listeners: []config.ProxyConfig{{Name: "port-3000", ServerPort: 3000, Upstream: "c"}},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return registry
+}
+
+func TestNextUpstreamSkipsTriedUpstreams(t *testing.T) {
+    registry := newTestRegistry(t)
+    h := &ProxyHandler{registry: registry}
+    cause := errors.New("connection refused")
+
+    tests := []struct {
+        name  string
+        sel   upstream.Selector
+        tries int
+    }{
+        // Selector theo port luôn trả về cùng upstream nên không retry
+        {"port selector", upstream.Selector{Port: 3000}, 0},
+        {"pool", upstream.Selector{Pool: "pool"}, 1},
+        {"pool with session", upstream.Selector{Pool: "pool", Session: "s1"}, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            up, err := registry.Select(tt.sel)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var tried []*upstream.Upstream
+            seen := map[*upstream.Upstream]bool{up: true}
+            for attempt := 1; ; attempt++ {
+                next, ok := h.nextUpstream(context.Background(), tt.sel, up, &tried, attempt, cause, zap.NewNop())
+                if !ok {
+                    if attempt-1 != tt.tries {
+                        t.Errorf("retried %d times, want %d", attempt-1, tt.tries)
+                    }
+                    return
+                }
+                if seen[next] {
+                    t.Fatalf("attempt %d retried on %s, which already failed", attempt+1, next.Name)
+                }
+                seen[next] = true
+                up = next
+            }
+        })
+    }
+}
+
+func TestCanReplay(t *testing.T) {
+    tests := []struct {
+        method  string
+        body    bool
+        started bool
+        want    bool
+    }{
+        {"GET", false, false, true},
+        {"POST", false, false, false},
+        {"POST", true, false, true},
+        {"POST", true, true, false},
+        {"PUT", true, true, false},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(tt.method, "http://example.com/", nil)
+        if tt.body {
+            r = httptest.NewRequest(tt.method, "http://example.com/", strings.NewReader("data"))
+        }
+        body := &replayGuardBody{ReadCloser: r.Body}
+        if tt.started {
+            body.Read(make([]byte, 1))
+        }
+        if got := canReplay(r, body); got != tt.want {
+            t.Errorf("canReplay(%s, body=%v, started=%v) = %v, want %v", tt.method, tt.body, tt.started, got, tt.want)
+        }
+    }
+}
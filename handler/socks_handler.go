@@ -0,0 +1,122 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "proxy-server/auth"
+    "proxy-server/guard"
+    "proxy-server/socks5"
+    "proxy-server/upstream"
+    "proxy-server/utils"
+
+    "go.uber.org/zap"
+)
+
+// AccessSOCKS áp dụng quy tắc IP nguồn của listener giống HTTP listener
+func (h *ProxyHandler) AccessSOCKS(req *socks5.Request) socks5.Access {
+    ip := clientIP(req.RemoteAddr.String())
+    switch h.authenticator.CheckAddress(ip) {
+    case auth.AccessDenied:
+        utils.GetLogger().Warn("Rejected client by IP rules",
+            zap.String("client_ip", ip),
+            zap.Int("proxy_port", h.config.ServerPort),
+        )
+        return socks5.AccessDenied
+    case auth.AccessGranted:
+        return socks5.AccessNoAuth
+    }
+    return socks5.AccessUserPass
+}
+
+// AuthenticateSOCKS dùng chung credentials và lockout với HTTP listener
+func (h *ProxyHandler) AuthenticateSOCKS(req *socks5.Request, username, password string) bool {
+    ip := clientIP(req.RemoteAddr.String())
+    if remaining, banned := h.banned(ip, username); banned {
+        utils.GetLogger().Warn("Rejected banned client",
+            zap.String("client_ip", ip),
+            zap.String("user", username),
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.Duration("remaining", remaining),
+        )
+        return false
+    }
+    if !h.authenticator.Check(username, password) {
+        h.authFailed(context.Background(), ip, username)
+        return false
+    }
+    h.authSucceeded(username)
+    return true
+}
+
+// DialSOCKS mở kết nối tới destination qua upstream được chọn giống HTTP listener
+func (h *ProxyHandler) DialSOCKS(ctx context.Context, req *socks5.Request) (net.Conn, error) {
+    logger := utils.GetLogger().With(
+        zap.String("user", req.Username),
+        zap.String("destination", req.DestAddr),
+    )
+    
+    // SOCKS5 CONNECT dùng chung danh sách port CONNECT với HTTP listener
+    if err := h.checkConnectPort(req.Username, req.DestAddr); err != nil {
+        return nil, &socks5.ReplyError{Code: socks5.ReplyNotAllowed}
+    }
+    
+    if err := h.checkDestination(ctx, req.Username, http.MethodConnect, req.DestAddr, 0); err != nil {
+        return nil, &socks5.ReplyError{Code: socks5.ReplyNotAllowed}
+    }
+    
+    ctx, err := h.guardDestination(ctx, req.Username, req.DestAddr, 0)
+    if err != nil {
+        var blocked *guard.BlockedError
+        if errors.As(err, &blocked) {
+            return nil, &socks5.ReplyError{Code: socks5.ReplyNotAllowed}
+        }
+        return nil, &socks5.ReplyError{Code: socks5.ReplyHostUnreachable}
+    }
+    
+    release, err := h.beginQuota(req.Username, true)
+    if err != nil {
+        logger.Warn("Quota exceeded",
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.Error(err),
+        )
+        return nil, &socks5.ReplyError{Code: socks5.ReplyNotAllowed}
+    }
+    
+    sel, err := h.selector(req.Username, "")
+    var up *upstream.Upstream
+    if err == nil {
+        up, err = h.registry.Select(sel)
+    }
+    if err != nil {
+        release()
+        logger.Warn("Failed to select upstream",
+            zap.Int("proxy_port", h.config.ServerPort),
+            zap.Error(err),
+        )
+        return nil, &socks5.ReplyError{Code: socks5.ReplyNotAllowed}
+    }
+    
+    conn, up, err := h.dialWithRetry(ctx, req.DestAddr, sel, up, logger)
+    if err != nil {
+        release()
+        return nil, err
+    }
+    
+    // Chỗ tunnel trong quota được trả lại khi tunnel đóng
+    tracked := conn.(*trackedConn)
+    untrack := tracked.done
+    tracked.done = func() {
+        untrack()
+        release()
+    }
+    
+    logger.Info("Selected upstream for SOCKS5 tunnel", zap.String("upstream", up.Name))
+    return conn, nil
+}
+
+// RelaySOCKS chuyển dữ liệu của SOCKS5 tunnel
+func (h *ProxyHandler) RelaySOCKS(req *socks5.Request, client, dest net.Conn) {
+    h.tunnel("socks5", req.Username, req.DestAddr, dest.(*trackedConn).upstream, client, dest)
+}
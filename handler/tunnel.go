@@ -0,0 +1,164 @@
+package handler
+
+import (
+    "context"
+    "io"
+    "net"
+    "proxy-server/metrics"
+    "proxy-server/throttle"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// TunnelInfo là snapshot của một tunnel CONNECT hoặc SOCKS5 đang mở
+type TunnelInfo struct {
+    ID          uint64    `json:"id"`
+    Listener    string    `json:"listener"`
+    // Port là HTTP port của listener, dùng làm label của metric
+    Port        int       `json:"port"`
+    Protocol    string    `json:"protocol"`
+    User        string    `json:"user,omitempty"`
+    Client      string    `json:"client"`
+    Destination string    `json:"destination"`
+    Upstream    string    `json:"upstream"`
+    Started     time.Time `json:"started"`
+    // BytesIn là số byte client gửi lên, BytesOut là số byte trả về client
+    BytesIn     int64     `json:"bytes_in"`
+    BytesOut    int64     `json:"bytes_out"`
+}
+
+// tunnel là một tunnel đang mở, đóng client và dest sẽ kết thúc tunnel
+type tunnel struct {
+    info     TunnelInfo
+    client   net.Conn
+    dest     net.Conn
+    bytesIn  atomic.Int64
+    bytesOut atomic.Int64
+}
+
+// Tunnels theo dõi các tunnel đang mở của mọi listener, dùng chung qua các lần reload
+type Tunnels struct {
+    mu     sync.Mutex
+    nextID uint64
+    active map[uint64]*tunnel
+}
+
+// NewTunnels tạo bộ theo dõi tunnel rỗng
+func NewTunnels() *Tunnels {
+    return &Tunnels{active: make(map[uint64]*tunnel)}
+}
+
+// List trả về các tunnel đang mở, sắp xếp theo ID
+func (t *Tunnels) List() []TunnelInfo {
+    t.mu.Lock()
+    list := make([]TunnelInfo, 0, len(t.active))
+    for _, tun := range t.active {
+        info := tun.info
+        info.BytesIn = tun.bytesIn.Load()
+        info.BytesOut = tun.bytesOut.Load()
+        list = append(list, info)
+    }
+    t.mu.Unlock()
+
+    sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+    return list
+}
+
+// Close đóng tunnel theo ID, trả về false nếu tunnel không còn mở
+func (t *Tunnels) Close(id uint64) bool {
+    t.mu.Lock()
+    tun, ok := t.active[id]
+    t.mu.Unlock()
+    if !ok {
+        return false
+    }
+
+    tun.client.Close()
+    tun.dest.Close()
+    return true
+}
+
+// Count trả về số tunnel đang mở của listener
+func (t *Tunnels) Count(listener string) int {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    n := 0
+    for _, tun := range t.active {
+        if tun.info.Listener == listener {
+            n++
+        }
+    }
+    return n
+}
+
+// run đăng ký tunnel, chuyển dữ liệu hai chiều và gỡ tunnel khi một phía đóng.
+// record nhận số byte mỗi lần đọc được (in từ client, out từ destination), record trả về lỗi
+// (ví dụ hết quota) thì tunnel bị đóng. limiters giới hạn băng thông của cả hai chiều
+func (t *Tunnels) run(record func(in, out int64) error, limiters []*throttle.Limiter, info TunnelInfo, client, dest net.Conn) {
+    tun := &tunnel{info: info, client: client, dest: dest}
+
+    t.mu.Lock()
+    t.nextID++
+    tun.info.ID = t.nextID
+    t.active[tun.info.ID] = tun
+    t.mu.Unlock()
+
+    port := metrics.Port(info.Port)
+    active := metrics.TunnelsActive.WithLabelValues(port, info.Upstream, info.Protocol)
+    metrics.TunnelsOpened.WithLabelValues(port, info.Upstream, info.Protocol).Inc()
+    active.Inc()
+
+    defer func() {
+        t.mu.Lock()
+        delete(t.active, tun.info.ID)
+        t.mu.Unlock()
+        active.Dec()
+    }()
+
+    go copyCounted(dest, client, &countingReader{
+        r:       throttle.Reader(context.Background(), client, throttle.Upload, limiters...),
+        counter: &tun.bytesIn,
+        metric:  metrics.Bytes.WithLabelValues(port, info.Upstream, "in"),
+        record:  func(n int64) error { return record(n, 0) },
+    })
+    copyCounted(client, dest, &countingReader{
+        r:       throttle.Reader(context.Background(), dest, throttle.Download, limiters...),
+        counter: &tun.bytesOut,
+        metric:  metrics.Bytes.WithLabelValues(port, info.Upstream, "out"),
+        record:  func(n int64) error { return record(0, n) },
+    })
+}
+
+// copyCounted chép dữ liệu từ src sang dst, đóng cả hai khi xong để phía còn lại cũng dừng
+func copyCounted(dst, src net.Conn, r *countingReader) {
+    defer dst.Close()
+    defer src.Close()
+
+    io.Copy(dst, r)
+}
+
+// countingReader đếm số byte đọc được cho tunnel, metric và thống kê lưu lượng.
+// Lỗi của record được trả về sau phần dữ liệu đã đọc để io.Copy dừng lại
+type countingReader struct {
+    r       io.Reader
+    counter *atomic.Int64
+    metric  prometheus.Counter
+    record  func(n int64) error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    if n > 0 {
+        c.counter.Add(int64(n))
+        c.metric.Add(float64(n))
+        if recordErr := c.record(int64(n)); recordErr != nil && err == nil {
+            err = recordErr
+        }
+    }
+    return n, err
+}
@@ -0,0 +1,240 @@
+package lockout
+
+import (
+    "net/netip"
+    "proxy-server/config"
+    "proxy-server/metrics"
+    "proxy-server/utils"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+const (
+    // KindIP là lệnh cấm theo IP nguồn
+    KindIP = "ip"
+    // KindUser là lệnh cấm theo tài khoản
+    KindUser = "user"
+)
+
+// Ban là một IP hoặc tài khoản đang bị cấm
+type Ban struct {
+    Kind  string    `json:"kind"`
+    Key   string    `json:"key"`
+    Until time.Time `json:"until"`
+    // Count là số lần bị cấm liên tiếp, thời gian cấm gấp đôi sau mỗi lần
+    Count int       `json:"count"`
+}
+
+type key struct {
+    kind string
+    name string
+}
+
+// entry là số lần sai trong window hiện tại và lịch sử cấm của một IP hoặc tài khoản
+type entry struct {
+    failures int
+    first    time.Time
+    bans     int
+    until    time.Time
+}
+
+// Lockout đếm lần xác thực sai và cấm tạm thời IP/tài khoản sai quá nhiều.
+// Trạng thái được giữ qua các lần reload, Configure chỉ đổi giới hạn
+type Lockout struct {
+    mu        sync.Mutex
+    config    config.LockoutConfig
+    allowlist []netip.Prefix
+    entries   map[key]*entry
+    pruned    time.Time
+}
+
+// New tạo Lockout chưa bật, gọi Configure để áp dụng cấu hình
+func New() *Lockout {
+    return &Lockout{entries: make(map[key]*entry)}
+}
+
+// Configure đổi cấu hình, allowlist đã được kiểm tra trong Config.Check.
+// Tắt lockout xóa mọi lệnh cấm đang có
+func (l *Lockout) Configure(cfg config.LockoutConfig) {
+    allowlist, _ := config.ParsePrefixes(cfg.Allowlist)
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.config = cfg
+    l.allowlist = allowlist
+    if !cfg.Enabled {
+        clear(l.entries)
+    }
+}
+
+// Banned kiểm tra IP nguồn và tài khoản (rỗng = chưa biết), trả về thời gian còn lại của lệnh cấm
+func (l *Lockout) Banned(ip, user string) (time.Duration, bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if !l.config.Enabled || l.allowedLocked(ip) {
+        return 0, false
+    }
+
+    now := time.Now()
+    var remaining time.Duration
+    for _, k := range keys(ip, user) {
+        if e, ok := l.entries[k]; ok && e.until.After(now) {
+            remaining = max(remaining, e.until.Sub(now))
+        }
+    }
+    return remaining, remaining > 0
+}
+
+// Fail ghi nhận một lần xác thực sai của user từ ip, trả về thời gian nên chờ trước khi trả lời.
+// IP hoặc tài khoản đủ MaxFailures lần sai trong Window bị cấm. user chỉ nên là tài khoản
+// có thật (rỗng nếu không), mỗi user mới là một entry cho tới khi được dọn
+func (l *Lockout) Fail(ip, user string) time.Duration {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if !l.config.Enabled || l.allowedLocked(ip) {
+        return 0
+    }
+
+    now := time.Now()
+    l.pruneLocked(now)
+
+    failures := 0
+    for _, k := range keys(ip, user) {
+        e, ok := l.entries[k]
+        if !ok {
+            e = &entry{}
+            l.entries[k] = e
+        }
+        if now.Sub(e.first) > l.config.Window {
+            e.failures = 0
+            e.first = now
+        }
+        e.failures++
+        failures = max(failures, e.failures)
+
+        if e.failures >= l.config.MaxFailures && !e.until.After(now) {
+            l.banLocked(k, e, now)
+        }
+    }
+    return min(l.config.Delay*time.Duration(failures), l.config.MaxDelay)
+}
+
+// banLocked cấm k, thời gian cấm gấp đôi sau mỗi lần bị cấm lại
+func (l *Lockout) banLocked(k key, e *entry, now time.Time) {
+    duration := l.config.BanDuration
+    for i := 0; i < e.bans && duration < l.config.MaxBan; i++ {
+        duration *= 2
+    }
+    duration = min(duration, l.config.MaxBan)
+
+    e.bans++
+    e.until = now.Add(duration)
+    e.failures = 0
+
+    metrics.AuthBans.WithLabelValues(k.kind).Inc()
+    utils.GetLogger().Warn("Banned after repeated authentication failures",
+        zap.String("kind", k.kind),
+        zap.String("key", k.name),
+        zap.Duration("duration", duration),
+        zap.Int("count", e.bans),
+    )
+}
+
+// Succeed xóa các lần sai của tài khoản sau khi xác thực thành công. Lần sai của IP
+// được giữ để một tài khoản đúng không che được việc dò mật khẩu tài khoản khác
+func (l *Lockout) Succeed(user string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if e, ok := l.entries[key{KindUser, user}]; ok {
+        e.failures = 0
+    }
+}
+
+// Bans trả về các lệnh cấm còn hiệu lực, sắp xếp theo loại và khóa
+func (l *Lockout) Bans() []Ban {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    bans := make([]Ban, 0)
+    for k, e := range l.entries {
+        if e.until.After(now) {
+            bans = append(bans, Ban{Kind: k.kind, Key: k.name, Until: e.until, Count: e.bans})
+        }
+    }
+    slices.SortFunc(bans, func(a, b Ban) int {
+        if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+            return c
+        }
+        return strings.Compare(a.Key, b.Key)
+    })
+    return bans
+}
+
+// Unban gỡ lệnh cấm và xóa lịch sử sai của IP hoặc tài khoản, trả về false nếu không bị cấm
+func (l *Lockout) Unban(kind, name string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    k := key{kind, name}
+    if kind == KindIP {
+        if addr, err := netip.ParseAddr(name); err == nil {
+            k.name = addr.Unmap().String()
+        }
+    }
+    e, ok := l.entries[k]
+    if !ok || !e.until.After(time.Now()) {
+        return false
+    }
+    delete(l.entries, k)
+    return true
+}
+
+// allowedLocked cho biết ip có nằm trong allowlist không
+func (l *Lockout) allowedLocked(ip string) bool {
+    addr, err := netip.ParseAddr(ip)
+    if err != nil {
+        return false
+    }
+    addr = addr.Unmap()
+    for _, prefix := range l.allowlist {
+        if prefix.Contains(addr) {
+            return true
+        }
+    }
+    return false
+}
+
+// pruneLocked xóa entry không còn bị cấm, hết window và đã yên ổn quá MaxBan, chạy tối đa mỗi Window một lần
+func (l *Lockout) pruneLocked(now time.Time) {
+    if now.Sub(l.pruned) < l.config.Window {
+        return
+    }
+    l.pruned = now
+
+    for k, e := range l.entries {
+        if now.Sub(e.first) > l.config.Window && now.Sub(e.until) > l.config.MaxBan {
+            delete(l.entries, k)
+        }
+    }
+}
+
+// keys trả về khóa của IP và tài khoản, user rỗng chỉ có khóa IP
+func keys(ip, user string) []key {
+    if addr, err := netip.ParseAddr(ip); err == nil {
+        ip = addr.Unmap().String()
+    }
+    k := []key{{KindIP, ip}}
+    if user != "" {
+        k = append(k, key{KindUser, user})
+    }
+    return k
+}
@@ -0,0 +1,157 @@
+package lockout
+
+import (
+    "os"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+    utils.Logger = zap.NewNop()
+    os.Exit(m.Run())
+}
+
+func newLockout(maxFailures int) *Lockout {
+    l := New()
+    l.Configure(config.LockoutConfig{
+        Enabled:     true,
+        MaxFailures: maxFailures,
+        Window:      10 * time.Minute,
+        BanDuration: time.Minute,
+        MaxBan:      5 * time.Minute,
+        Delay:       100 * time.Millisecond,
+        MaxDelay:    350 * time.Millisecond,
+        Allowlist:   []string{"192.0.2.0/24"},
+    })
+    return l
+}
+
+func TestFailDelay(t *testing.T) {
+    l := newLockout(10)
+    for i, want := range []time.Duration{100, 200, 300, 350, 350} {
+        if got := l.Fail("198.51.100.1", "alice"); got != want*time.Millisecond {
+            t.Errorf("failure %d: delay = %v, want %v", i+1, got, want*time.Millisecond)
+        }
+    }
+}
+
+func TestBanAfterMaxFailures(t *testing.T) {
+    tests := []struct {
+        name   string
+        ip     string
+        user   string
+        banned bool
+    }{
+        {"same ip other user", "198.51.100.1", "bob", true},
+        {"same ip mapped ipv6", "::ffff:198.51.100.1", "", true},
+        {"same user other ip", "203.0.113.9", "alice", true},
+        {"other ip and user", "203.0.113.9", "bob", false},
+    }
+
+    l := newLockout(3)
+    for i := 0; i < 3; i++ {
+        l.Fail("198.51.100.1", "alice")
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            remaining, banned := l.Banned(tt.ip, tt.user)
+            if banned != tt.banned {
+                t.Fatalf("Banned(%q, %q) = %v, want %v", tt.ip, tt.user, banned, tt.banned)
+            }
+            if banned && (remaining <= 0 || remaining > time.Minute) {
+                t.Errorf("remaining = %v, want up to the first ban of 1m", remaining)
+            }
+        })
+    }
+}
+
+func TestBanDurationDoubles(t *testing.T) {
+    l := newLockout(3)
+    e := &entry{}
+    now := time.Now()
+    for i, want := range []time.Duration{1, 2, 4, 5, 5} {
+        l.banLocked(key{KindIP, "198.51.100.1"}, e, now)
+        if got := e.until.Sub(now); got != want*time.Minute {
+            t.Errorf("ban %d: duration = %v, want %v", i+1, got, want*time.Minute)
+        }
+        if e.bans != i+1 {
+            t.Errorf("ban %d: count = %d", i+1, e.bans)
+        }
+    }
+}
+
+func TestFailuresOutsideWindowReset(t *testing.T) {
+    l := newLockout(3)
+    l.Fail("198.51.100.1", "")
+    l.Fail("198.51.100.1", "")
+    l.entries[key{KindIP, "198.51.100.1"}].first = time.Now().Add(-11 * time.Minute)
+
+    if got := l.Fail("198.51.100.1", ""); got != 100*time.Millisecond {
+        t.Errorf("delay after window = %v, want the first delay", got)
+    }
+    if _, banned := l.Banned("198.51.100.1", ""); banned {
+        t.Error("banned for failures spread over two windows")
+    }
+}
+
+func TestAllowlistIsNeverBanned(t *testing.T) {
+    l := newLockout(1)
+    for _, ip := range []string{"192.0.2.7", "::ffff:192.0.2.7"} {
+        if got := l.Fail(ip, "alice"); got != 0 {
+            t.Errorf("Fail(%s) delay = %v, want 0", ip, got)
+        }
+        if _, banned := l.Banned(ip, "alice"); banned {
+            t.Errorf("Banned(%s) = true for an allowlisted address", ip)
+        }
+    }
+}
+
+func TestSucceedKeepsIPFailures(t *testing.T) {
+    l := newLockout(3)
+    l.Fail("198.51.100.1", "alice")
+    l.Fail("198.51.100.1", "alice")
+    l.Succeed("alice")
+
+    if _, banned := l.Banned("", "alice"); banned {
+        t.Fatal("user banned after a successful login")
+    }
+    l.Fail("198.51.100.1", "carol")
+    if _, banned := l.Banned("198.51.100.1", ""); !banned {
+        t.Error("ip not banned: failures of other accounts were cleared by a successful login")
+    }
+    if _, banned := l.Banned("", "alice"); banned {
+        t.Error("alice banned although the account failures were cleared")
+    }
+}
+
+func TestUnban(t *testing.T) {
+    l := newLockout(1)
+    l.Fail("198.51.100.1", "alice")
+
+    if !l.Unban(KindIP, "::ffff:198.51.100.1") {
+        t.Fatal("Unban(ip) = false for a banned address")
+    }
+    if l.Unban(KindIP, "198.51.100.1") {
+        t.Error("Unban(ip) = true twice")
+    }
+    if got := l.Bans(); len(got) != 1 || got[0].Kind != KindUser || got[0].Key != "alice" {
+        t.Errorf("Bans() = %+v, want only the user ban", got)
+    }
+}
+
+func TestDisabled(t *testing.T) {
+    l := newLockout(1)
+    l.Fail("198.51.100.1", "alice")
+    l.Configure(config.LockoutConfig{Enabled: false})
+
+    if _, banned := l.Banned("198.51.100.1", "alice"); banned {
+        t.Error("ban kept after disabling lockout")
+    }
+    if got := l.Fail("198.51.100.1", "alice"); got != 0 {
+        t.Errorf("Fail() delay = %v with lockout disabled", got)
+    }
+}
@@ -2,100 +2,211 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "net/http"
     "os"
     "os/signal"
+    "proxy-server/accounting"
+    "proxy-server/admin"
     "proxy-server/config"
-    "proxy-server/handler"
+    "proxy-server/metrics"
+    "proxy-server/quota"
+    "proxy-server/server"
     "proxy-server/utils"
-    "sync"
     "syscall"
-    "time"
     "go.uber.org/zap"
 )
 
 func main() {
+    configPath := flag.String("config", "", "path to YAML/JSON config file (default: list_proxy.txt with per-port listeners)")
+    revealSecrets := flag.Bool("reveal-secrets", false, "print passwords and credentials in stdout and logs (debugging only)")
+    flag.Parse()
+    utils.SetRevealSecrets(*revealSecrets)
+    
+    // Lệnh validate: proxy-forward validate [--config path]
+    if flag.Arg(0) == "validate" {
+        flag.CommandLine.Parse(flag.Args()[1:])
+        os.Exit(runValidate(*configPath))
+    }
+    
+    // Lệnh usage: proxy-forward usage --file usage.json [--from ...] [--to ...]
+    if flag.Arg(0) == "usage" {
+        os.Exit(runUsage(flag.Args()[1:]))
+    }
+    
+    // Lệnh gen-creds: proxy-forward [--config path] gen-creds --store users.htpasswd [...]
+    if flag.Arg(0) == "gen-creds" {
+        os.Exit(runGenCreds(*configPath, flag.Args()[1:]))
+    }
+    
     if err := utils.InitLogger(); err != nil {
         panic("Failed to initialize logger: " + err.Error())
     }
     defer utils.GetLogger().Sync()
     
     logger := utils.GetLogger()
+    if utils.RevealSecrets() {
+        logger.Warn("Secrets are not redacted in logs, do not use --reveal-secrets in production")
+    }
+    
+    cfg, err := config.LoadConfig(*configPath)
+    if err != nil {
+        logger.Fatal("Failed to load config", zap.String("config", *configPath), zap.Error(err))
+    }
+    
+    for _, listErr := range cfg.ListErrors {
+        logger.Warn("Skipped invalid proxy list entry", zap.Error(listErr))
+    }
+    
+    // Thống kê lưu lượng theo tài khoản, số liệu cũ được đọc lại từ file
+    var usage *accounting.Ledger
+    if cfg.Accounting.File != "" {
+        usage, err = accounting.Open(cfg.Accounting)
+        if err != nil {
+            logger.Fatal("Failed to load traffic accounting", zap.String("file", cfg.Accounting.File), zap.Error(err))
+        }
+        usage.Start()
+    }
     
-    cfg := config.LoadConfig()
+    // Lưu lượng đã dùng của quota được đọc lại để quota không bị đặt lại khi khởi động lại
+    quotas, err := quota.Open(cfg.Quotas)
+    if err != nil {
+        logger.Fatal("Failed to load quota state", zap.String("file", cfg.Quotas.File), zap.Error(err))
+    }
+    quotas.Start()
     
-    if len(cfg.Proxies) == 0 {
-        logger.Fatal("No proxies configured")
+    manager, err := server.NewManager(cfg, usage, quotas)
+    if err != nil {
+        logger.Fatal("Failed to create upstreams", zap.Error(err))
     }
     
     logger.Info("Starting multiple proxy servers", 
-        zap.Int("proxy_count", len(cfg.Proxies)))
+        zap.Int("proxy_count", len(cfg.Upstreams)),
+        zap.Int("listener_count", len(cfg.Listeners)))
     
-    var wg sync.WaitGroup
-    servers := make([]*http.Server, len(cfg.Proxies))
+    if err := manager.Start(); err != nil {
+        logger.Error("Failed to start listeners", zap.Error(err))
+    }
     
-    // Khởi động server cho mỗi proxy
-    for i, proxyCfg := range cfg.Proxies {
-        wg.Add(1)
+    // Endpoint /metrics cho Prometheus, không yêu cầu xác thực
+    var metricsServer *http.Server
+    if cfg.Metrics.Listen != "" {
+        metrics.RegisterUpstreams(manager.Registry())
         
-        go func(index int, cfg config.ProxyConfig) {
-            defer wg.Done()
-            
-            logger := utils.GetLogger().With(
-                zap.Int("proxy_index", index),
-                zap.String("server_address", cfg.GetServerAddress()),
-                zap.String("proxy_address", cfg.GetProxyAddress()),
-            )
-            
-            // Tạo proxy handler cho proxy này
-            proxyHandler := handler.NewProxyHandler(&cfg)
-            
-            server := &http.Server{
-                Addr:         cfg.GetServerAddress(),
-                Handler:      proxyHandler,
-                ReadTimeout:  30 * time.Second,
-                WriteTimeout: 30 * time.Second,
-                IdleTimeout:  120 * time.Second,
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", metrics.Handler())
+        metricsServer = &http.Server{Addr: cfg.Metrics.Listen, Handler: mux}
+        
+        logger.Info("Starting metrics endpoint", zap.String("server_address", cfg.Metrics.Listen))
+        go func() {
+            if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                logger.Error("Metrics endpoint failed", zap.Error(err))
             }
-            
-            servers[index] = server
-            
-            logger.Info("Starting proxy server")
-            
-            if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-                logger.Error("Server failed", zap.Error(err))
+        }()
+    }
+    
+    // Admin API chạy trên listener riêng, cấu hình chỉ được đọc khi khởi động
+    var adminServer *admin.Server
+    if cfg.Admin.Listen != "" {
+        adminServer = admin.NewServer(cfg.Admin, cfg.Timeouts, manager)
+        go func() {
+            if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                logger.Error("Admin API failed", zap.Error(err))
+            }
+        }()
+    }
+    
+    // Reload được kích hoạt bởi SIGHUP hoặc khi file cấu hình thay đổi
+    reloadRequests := make(chan struct{}, 1)
+    requestReload := func() {
+        select {
+        case reloadRequests <- struct{}{}:
+        default:
+        }
+    }
+    
+    var watcher *config.Watcher
+    if cfg.Watch {
+        watcher, err = config.NewWatcher(requestReload)
+        if err != nil {
+            logger.Error("Failed to watch config files", zap.Error(err))
+        } else {
+            defer watcher.Close()
+            if err := watcher.Watch(cfg.Files); err != nil {
+                logger.Error("Failed to watch config files", zap.Strings("files", cfg.Files), zap.Error(err))
             }
-        }(i, proxyCfg)
+        }
+    }
+    
+    reload := func() {
+        newCfg, err := config.LoadConfig(*configPath)
+        if err != nil {
+            logger.Error("Failed to reload configuration, keeping current one", zap.Error(err))
+            return
+        }
+        for _, listErr := range newCfg.ListErrors {
+            logger.Warn("Skipped invalid proxy list entry", zap.Error(listErr))
+        }
+        
+        // Cấu hình chưa được áp dụng thì giữ cfg cũ cho lần reload sau và lúc shutdown,
+        // file vẫn được theo dõi để lần sửa tiếp theo được reload
+        if err := manager.Reload(newCfg); err != nil {
+            logger.Error("Failed to reload configuration, keeping current one", zap.Error(err))
+        } else {
+            logger.Info("Configuration reloaded",
+                zap.Int("proxy_count", len(newCfg.Upstreams)),
+                zap.Int("listener_count", len(newCfg.Listeners)))
+            cfg = newCfg
+        }
         
-        // Chờ một chút giữa các server để tránh conflict
-        time.Sleep(100 * time.Millisecond)
+        if watcher != nil {
+            if err := watcher.Watch(newCfg.Files); err != nil {
+                logger.Error("Failed to watch config files", zap.Strings("files", newCfg.Files), zap.Error(err))
+            }
+        }
     }
     
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    
     // Wait for interrupt signal
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-    <-stop
+    
+    for running := true; running; {
+        select {
+        case <-hup:
+            logger.Info("Received SIGHUP, reloading configuration")
+            reload()
+        case <-reloadRequests:
+            logger.Info("Config file changed, reloading configuration")
+            reload()
+        case <-stop:
+            running = false
+        }
+    }
     
     logger.Info("Shutting down all servers...")
     
     // Graceful shutdown cho tất cả servers
-    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
     defer cancel()
     
-    for i, server := range servers {
-        if server != nil {
-            logger.Info("Shutting down server", 
-                zap.Int("proxy_index", i),
-                zap.String("address", server.Addr))
-            
-            if err := server.Shutdown(ctx); err != nil {
-                logger.Error("Server shutdown failed", 
-                    zap.Int("proxy_index", i),
-                    zap.Error(err))
-            }
+    if adminServer != nil {
+        adminServer.Shutdown(ctx)
+    }
+    if metricsServer != nil {
+        metricsServer.Shutdown(ctx)
+    }
+    manager.Shutdown(ctx)
+    if err := quotas.Close(); err != nil {
+        logger.Error("Failed to save quota state", zap.String("file", cfg.Quotas.File), zap.Error(err))
+    }
+    if usage != nil {
+        if err := usage.Close(); err != nil {
+            logger.Error("Failed to save traffic accounting", zap.String("file", cfg.Accounting.File), zap.Error(err))
         }
     }
-    
-    wg.Wait()
     logger.Info("All servers stopped")
 }
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry chứa mọi metric của proxy, tách khỏi registry mặc định của prometheus
+var Registry = prometheus.NewRegistry()
+
+var (
+    // Requests đếm request HTTP và CONNECT theo port, upstream, method và status trả về client
+    Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_requests_total",
+        Help: "HTTP and CONNECT requests by listener port, upstream, method and status code.",
+    }, []string{"port", "upstream", "method", "status"})
+
+    // AuthFailures đếm lần xác thực thất bại, reason: missing, malformed, invalid hoặc denied (chặn theo IP)
+    AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_auth_failures_total",
+        Help: "Failed client authentications by listener port and reason.",
+    }, []string{"port", "reason"})
+
+    // AuthBans đếm lệnh cấm tạm thời sau nhiều lần xác thực sai, kind: ip hoặc user
+    AuthBans = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_auth_bans_total",
+        Help: "Temporary bans after repeated authentication failures by kind (ip or user).",
+    }, []string{"kind"})
+
+    // ConnectRejected đếm tunnel CONNECT và SOCKS5 bị từ chối vì port đích không nằm trong danh sách cho phép
+    ConnectRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_connect_rejected_total",
+        Help: "CONNECT and SOCKS5 tunnels rejected because the destination port is not allowed, by listener port.",
+    }, []string{"port"})
+
+    // TunnelsOpened đếm tunnel CONNECT và SOCKS5 đã mở
+    TunnelsOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_tunnels_opened_total",
+        Help: "CONNECT and SOCKS5 tunnels opened by listener port, upstream and protocol.",
+    }, []string{"port", "upstream", "protocol"})
+
+    // TunnelsActive là số tunnel đang mở
+    TunnelsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "proxy_tunnels_active",
+        Help: "CONNECT and SOCKS5 tunnels currently open by listener port, upstream and protocol.",
+    }, []string{"port", "upstream", "protocol"})
+
+    // Bytes đếm dữ liệu qua proxy, direction in = client gửi lên, out = trả về client
+    Bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "proxy_bytes_total",
+        Help: "Bytes relayed by listener port, upstream and direction (in: from client, out: to client).",
+    }, []string{"port", "upstream", "direction"})
+
+    // DialDuration đo thời gian mở kết nối tới destination qua upstream, result: success hoặc error
+    DialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "proxy_upstream_dial_duration_seconds",
+        Help:    "Time to open a connection to the destination through an upstream.",
+        Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+    }, []string{"port", "upstream", "result"})
+)
+
+func init() {
+    Registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+        Requests,
+        AuthFailures,
+        AuthBans,
+        ConnectRejected,
+        TunnelsOpened,
+        TunnelsActive,
+        Bytes,
+        DialDuration,
+    )
+}
+
+// Handler trả về HTTP handler cho endpoint /metrics
+func Handler() http.Handler {
+    return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Port chuyển port thành giá trị label
+func Port(port int) string {
+    return strconv.Itoa(port)
+}
+
+// Method giới hạn label method ở các method chuẩn để client không tạo được label tùy ý
+func Method(method string) string {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+        http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+        return method
+    }
+    return "OTHER"
+}
+
+// Status chuyển HTTP status thành giá trị label
+func Status(code int) string {
+    return strconv.Itoa(code)
+}
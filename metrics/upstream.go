@@ -0,0 +1,65 @@
+package metrics
+
+import (
+    "proxy-server/upstream"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    upstreamHealthyDesc = prometheus.NewDesc(
+        "proxy_upstream_healthy",
+        "Whether the upstream is available (1) or skipped because of health checks or its circuit breaker (0).",
+        []string{"upstream"}, nil,
+    )
+    upstreamCheckHealthyDesc = prometheus.NewDesc(
+        "proxy_upstream_check_healthy",
+        "Result of the upstream health checks, ignoring the circuit breaker.",
+        []string{"upstream"}, nil,
+    )
+    upstreamBreakerDesc = prometheus.NewDesc(
+        "proxy_upstream_breaker_state",
+        "Circuit breaker state of the upstream: 0 closed, 1 open, 2 half-open.",
+        []string{"upstream"}, nil,
+    )
+    upstreamActiveDesc = prometheus.NewDesc(
+        "proxy_upstream_active_connections",
+        "Requests and tunnels currently using the upstream.",
+        []string{"upstream"}, nil,
+    )
+)
+
+// upstreamCollector đọc trạng thái upstream từ registry mỗi lần scrape,
+// upstream bị xóa khi reload sẽ tự biến mất khỏi metric
+type upstreamCollector struct {
+    registry *upstream.Registry
+}
+
+// RegisterUpstreams thêm metric health, circuit breaker và kết nối đang mở của các upstream
+func RegisterUpstreams(registry *upstream.Registry) {
+    Registry.MustRegister(&upstreamCollector{registry: registry})
+}
+
+func (c *upstreamCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- upstreamHealthyDesc
+    ch <- upstreamCheckHealthyDesc
+    ch <- upstreamBreakerDesc
+    ch <- upstreamActiveDesc
+}
+
+func (c *upstreamCollector) Collect(ch chan<- prometheus.Metric) {
+    for _, u := range c.registry.All() {
+        status := u.HealthStatus()
+        ch <- prometheus.MustNewConstMetric(upstreamHealthyDesc, prometheus.GaugeValue, boolValue(status.Healthy), u.Name)
+        ch <- prometheus.MustNewConstMetric(upstreamCheckHealthyDesc, prometheus.GaugeValue, boolValue(status.CheckHealthy), u.Name)
+        ch <- prometheus.MustNewConstMetric(upstreamBreakerDesc, prometheus.GaugeValue, float64(u.BreakerState()), u.Name)
+        ch <- prometheus.MustNewConstMetric(upstreamActiveDesc, prometheus.GaugeValue, float64(u.ActiveConns()), u.Name)
+    }
+}
+
+func boolValue(b bool) float64 {
+    if b {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,300 @@
+package quota
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// fileVersion là phiên bản định dạng file lưu trạng thái quota
+const fileVersion = 1
+
+// ExceededError cho biết tài khoản đã vượt giới hạn nào và khi nào được dùng lại
+type ExceededError struct {
+    Reason     string
+    RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+    return e.Reason
+}
+
+// usage là lưu lượng đã dùng của một tài khoản. Ngày và tháng tính theo UTC,
+// số request trong phút và số tunnel đang mở chỉ giữ trong bộ nhớ
+type usage struct {
+    Day        string `json:"day"`
+    DayBytes   int64  `json:"day_bytes"`
+    Month      string `json:"month"`
+    MonthBytes int64  `json:"month_bytes"`
+
+    minute   int64
+    requests int
+    tunnels  int
+}
+
+// roll đặt lại bộ đếm khi đã sang ngày hoặc tháng mới
+func (u *usage) roll(now time.Time) {
+    if day := now.Format("2006-01-02"); u.Day != day {
+        u.Day = day
+        u.DayBytes = 0
+    }
+    if month := now.Format("2006-01"); u.Month != month {
+        u.Month = month
+        u.MonthBytes = 0
+    }
+}
+
+type stateFile struct {
+    Version int               `json:"version"`
+    Users   map[string]*usage `json:"users"`
+}
+
+// Tracker kiểm tra và ghi nhận quota của các tài khoản. Lưu lượng trong ngày/tháng
+// được ghi xuống file định kỳ và khi Close để quota không bị đặt lại khi khởi động lại
+type Tracker struct {
+    config config.QuotaConfig
+
+    mu     sync.Mutex
+    limits map[string]config.QuotaLimits
+    users  map[string]*usage
+    dirty  bool
+
+    stop chan struct{}
+    done chan struct{}
+}
+
+// Open đọc file trạng thái (nếu đã có), gọi Start để bắt đầu ghi định kỳ
+func Open(cfg config.QuotaConfig) (*Tracker, error) {
+    t := &Tracker{
+        config: cfg,
+        limits: make(map[string]config.QuotaLimits),
+        users:  make(map[string]*usage),
+    }
+    if err := t.load(); err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// SetLimits thay giới hạn của các tài khoản, dùng khi khởi động và reload.
+// Lưu lượng đã dùng được giữ nguyên
+func (t *Tracker) SetLimits(limits map[string]config.QuotaLimits) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.limits = limits
+}
+
+// Begin kiểm tra quota trước khi proxy request của user và tính request vào giới hạn theo phút.
+// tunnel = true giữ một chỗ trong MaxTunnels, gọi release khi tunnel đóng
+func (t *Tracker) Begin(user string, tunnel bool) (release func(), err error) {
+    release = func() {}
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    limits, ok := t.limits[user]
+    if !ok || !limits.Enabled() {
+        return release, nil
+    }
+
+    now := time.Now().UTC()
+    u := t.userLocked(user, now)
+
+    if err := trafficExceeded(limits, u, now); err != nil {
+        return release, err
+    }
+
+    if minute := now.Unix() / 60; u.minute != minute {
+        u.minute = minute
+        u.requests = 0
+    }
+    if limits.RequestsPerMinute > 0 && u.requests >= limits.RequestsPerMinute {
+        return release, &ExceededError{
+            Reason:     fmt.Sprintf("rate limit of %d requests per minute exceeded", limits.RequestsPerMinute),
+            RetryAfter: time.Unix((u.minute+1)*60, 0).Sub(now),
+        }
+    }
+    if tunnel && limits.MaxTunnels > 0 && u.tunnels >= limits.MaxTunnels {
+        return release, &ExceededError{
+            Reason: fmt.Sprintf("limit of %d concurrent tunnels reached", limits.MaxTunnels),
+        }
+    }
+
+    u.requests++
+    if !tunnel {
+        return release, nil
+    }
+
+    u.tunnels++
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            t.mu.Lock()
+            u.tunnels--
+            t.mu.Unlock()
+        })
+    }, nil
+}
+
+// Add cộng lưu lượng vào quota ngày và tháng của user, bỏ qua tài khoản không giới hạn lưu lượng.
+// Trả về *ExceededError khi lưu lượng đã chạm giới hạn, tunnel và request đang chạy phải dừng lại
+func (t *Tracker) Add(user string, bytes int64) error {
+    if bytes <= 0 {
+        return nil
+    }
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    limits := t.limits[user]
+    if limits.DailyBytes == 0 && limits.MonthlyBytes == 0 {
+        return nil
+    }
+
+    now := time.Now().UTC()
+    u := t.userLocked(user, now)
+    u.DayBytes += bytes
+    u.MonthBytes += bytes
+    t.dirty = true
+    return trafficExceeded(limits, u, now)
+}
+
+// trafficExceeded kiểm tra lưu lượng trong ngày và tháng của u với giới hạn
+func trafficExceeded(limits config.QuotaLimits, u *usage, now time.Time) error {
+    if limits.DailyBytes > 0 && u.DayBytes >= int64(limits.DailyBytes) {
+        tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+        return &ExceededError{
+            Reason:     fmt.Sprintf("daily traffic quota of %s exceeded", limits.DailyBytes),
+            RetryAfter: tomorrow.Sub(now),
+        }
+    }
+    if limits.MonthlyBytes > 0 && u.MonthBytes >= int64(limits.MonthlyBytes) {
+        nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+        return &ExceededError{
+            Reason:     fmt.Sprintf("monthly traffic quota of %s exceeded", limits.MonthlyBytes),
+            RetryAfter: nextMonth.Sub(now),
+        }
+    }
+    return nil
+}
+
+func (t *Tracker) userLocked(user string, now time.Time) *usage {
+    u, ok := t.users[user]
+    if !ok {
+        u = &usage{}
+        t.users[user] = u
+    }
+    u.roll(now)
+    return u
+}
+
+// Start ghi trạng thái xuống file sau mỗi FlushInterval, không làm gì nếu không cấu hình file
+func (t *Tracker) Start() {
+    if t.config.File == "" {
+        return
+    }
+    t.stop = make(chan struct{})
+    t.done = make(chan struct{})
+
+    go func() {
+        defer close(t.done)
+
+        ticker := time.NewTicker(t.config.FlushInterval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-t.stop:
+                return
+            case <-ticker.C:
+                if err := t.Flush(); err != nil {
+                    utils.GetLogger().Error("Failed to save quota state", zap.String("file", t.config.File), zap.Error(err))
+                }
+            }
+        }
+    }()
+}
+
+// Close dừng ghi định kỳ và ghi lần cuối
+func (t *Tracker) Close() error {
+    if t.stop != nil {
+        close(t.stop)
+        <-t.done
+    }
+    return t.Flush()
+}
+
+// Flush ghi file nếu lưu lượng đã thay đổi, tài khoản không còn dùng trong tháng này bị bỏ
+func (t *Tracker) Flush() error {
+    if t.config.File == "" {
+        return nil
+    }
+
+    t.mu.Lock()
+    month := time.Now().UTC().Format("2006-01")
+    data := stateFile{Version: fileVersion, Users: make(map[string]*usage)}
+    for user, u := range t.users {
+        if u.Month != month && u.tunnels == 0 {
+            delete(t.users, user)
+            continue
+        }
+        if u.DayBytes > 0 || u.MonthBytes > 0 {
+            saved := *u
+            data.Users[user] = &saved
+        }
+    }
+    if !t.dirty {
+        t.mu.Unlock()
+        return nil
+    }
+    t.dirty = false
+    t.mu.Unlock()
+
+    encoded, err := json.Marshal(data)
+    if err == nil {
+        err = utils.WriteFileAtomic(t.config.File, encoded)
+    }
+    if err != nil {
+        // Ghi lỗi thì lần flush sau ghi lại
+        t.mu.Lock()
+        t.dirty = true
+        t.mu.Unlock()
+    }
+    return err
+}
+
+func (t *Tracker) load() error {
+    if t.config.File == "" {
+        return nil
+    }
+
+    raw, err := os.ReadFile(t.config.File)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var data stateFile
+    if err := json.Unmarshal(raw, &data); err != nil {
+        return fmt.Errorf("%s: %w", t.config.File, err)
+    }
+    if data.Version != fileVersion {
+        return fmt.Errorf("%s: unsupported version %d", t.config.File, data.Version)
+    }
+    for user, u := range data.Users {
+        if u != nil {
+            t.users[user] = u
+        }
+    }
+    return nil
+}
@@ -0,0 +1,141 @@
+package quota
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "proxy-server/config"
+    "strings"
+    "testing"
+)
+
+func newTracker(t *testing.T, limits map[string]config.QuotaLimits) *Tracker {
+    t.Helper()
+    tr, err := Open(config.QuotaConfig{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tr.SetLimits(limits)
+    return tr
+}
+
+func TestBeginLimits(t *testing.T) {
+    tests := []struct {
+        name    string
+        limits  config.QuotaLimits
+        used    int64
+        tunnel  bool
+        allowed int
+        reason  string
+    }{
+        {"no limits", config.QuotaLimits{}, 0, true, 5, ""},
+        {"requests per minute", config.QuotaLimits{RequestsPerMinute: 3}, 0, false, 3, "rate limit of 3 requests per minute"},
+        {"tunnels", config.QuotaLimits{MaxTunnels: 2}, 0, true, 2, "limit of 2 concurrent tunnels"},
+        {"tunnels do not limit requests", config.QuotaLimits{MaxTunnels: 1}, 0, false, 5, ""},
+        {"daily traffic used up", config.QuotaLimits{DailyBytes: 1000}, 1000, false, 0, "daily traffic quota of"},
+        {"monthly traffic used up", config.QuotaLimits{MonthlyBytes: 1000}, 1500, true, 0, "monthly traffic quota of"},
+        {"traffic below limit", config.QuotaLimits{DailyBytes: 1000}, 999, false, 5, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tr := newTracker(t, map[string]config.QuotaLimits{"alice": tt.limits})
+            tr.Add("alice", tt.used)
+
+            allowed := 0
+            var err error
+            for i := 0; i < 5; i++ {
+                if _, err = tr.Begin("alice", tt.tunnel); err != nil {
+                    break
+                }
+                allowed++
+            }
+            if allowed != tt.allowed {
+                t.Errorf("allowed %d requests, want %d", allowed, tt.allowed)
+            }
+            if tt.reason == "" {
+                if err != nil {
+                    t.Errorf("Begin() = %v, want no limit", err)
+                }
+                return
+            }
+            var exceeded *ExceededError
+            if !errors.As(err, &exceeded) || !strings.Contains(exceeded.Reason, tt.reason) {
+                t.Errorf("Begin() = %v, want %q", err, tt.reason)
+            }
+        })
+    }
+}
+
+func TestReleaseFreesTunnel(t *testing.T) {
+    tr := newTracker(t, map[string]config.QuotaLimits{"alice": {MaxTunnels: 1}})
+
+    release, err := tr.Begin("alice", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tr.Begin("alice", true); err == nil {
+        t.Fatal("second tunnel allowed with max_tunnels 1")
+    }
+    release()
+    release()
+    if _, err := tr.Begin("alice", true); err != nil {
+        t.Fatalf("Begin() after release = %v", err)
+    }
+    if _, err := tr.Begin("alice", true); err == nil {
+        t.Error("double release freed two tunnels")
+    }
+}
+
+func TestAddReportsCapWhileDataFlows(t *testing.T) {
+    tr := newTracker(t, map[string]config.QuotaLimits{
+        "alice": {DailyBytes: 100},
+        "bob":   {RequestsPerMinute: 1},
+    })
+
+    for i, tt := range []struct {
+        bytes    int64
+        exceeded bool
+    }{
+        {60, false},
+        {0, false},
+        {39, false},
+        {1, true},
+        {10, true},
+    } {
+        err := tr.Add("alice", tt.bytes)
+        if (err != nil) != tt.exceeded {
+            t.Errorf("Add #%d (%d bytes) = %v, want exceeded %v", i+1, tt.bytes, err, tt.exceeded)
+        }
+    }
+    if err := tr.Add("bob", 1<<40); err != nil {
+        t.Errorf("Add() = %v for an account without traffic limits", err)
+    }
+    if err := tr.Add("carol", 1<<40); err != nil {
+        t.Errorf("Add() = %v for an account without limits", err)
+    }
+}
+
+func TestFlushRetriesAfterWriteError(t *testing.T) {
+    dir := t.TempDir()
+    tr := newTracker(t, map[string]config.QuotaLimits{"alice": {DailyBytes: 1000}})
+    tr.config.File = filepath.Join(dir, "missing", "quota.json")
+    tr.Add("alice", 10)
+
+    if err := tr.Flush(); err == nil {
+        t.Fatal("Flush() into a missing directory succeeded")
+    }
+
+    tr.config.File = filepath.Join(dir, "quota.json")
+    if err := tr.Flush(); err != nil {
+        t.Fatalf("Flush() = %v", err)
+    }
+    raw, err := os.ReadFile(tr.config.File)
+    if err != nil {
+        t.Fatalf("usage was not written after the failed flush: %v", err)
+    }
+    var data stateFile
+    if err := json.Unmarshal(raw, &data); err != nil || data.Users["alice"] == nil || data.Users["alice"].DayBytes != 10 {
+        t.Errorf("saved state = %s, want 10 bytes for alice", raw)
+    }
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+    "fmt"
+    "proxy-server/config"
+)
+
+// BandwidthStatus là giới hạn băng thông đang áp dụng, tính bằng byte/giây cho mỗi chiều.
+// 0 = không giới hạn
+type BandwidthStatus struct {
+    Global    int64            `json:"global"`
+    Listeners map[string]int64 `json:"listeners"`
+    Users     map[string]int64 `json:"users"`
+}
+
+// Bandwidth trả về giới hạn toàn cục, của mọi listener và mọi tài khoản
+func (m *Manager) Bandwidth() BandwidthStatus {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    status := BandwidthStatus{
+        Global:    int64(m.config.Bandwidth),
+        Listeners: make(map[string]int64),
+        Users:     make(map[string]int64),
+    }
+    for _, l := range m.config.Listeners {
+        status.Listeners[l.Name] = int64(l.Bandwidth)
+        for _, u := range l.Users {
+            status.Users[u.Username] = int64(u.Bandwidth)
+        }
+    }
+    return status
+}
+
+// SetGlobalBandwidth đổi giới hạn tổng băng thông, áp dụng ngay cho cả tunnel đang mở
+func (m *Manager) SetGlobalBandwidth(rate config.ByteSize) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    ov := m.overrides.clone()
+    ov.globalBandwidth = &rate
+    return m.apply(m.base, ov)
+}
+
+// SetListenerBandwidth đổi giới hạn băng thông của listener theo tên hoặc port
+func (m *Manager) SetListenerBandwidth(name string, rate config.ByteSize) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    l, err := m.findListener(name)
+    if err != nil {
+        return err
+    }
+
+    ov := m.overrides.clone()
+    ov.listenerBandwidth[l.Name] = rate
+    return m.apply(m.base, ov)
+}
+
+// SetUserBandwidth đổi giới hạn băng thông của tài khoản trên mọi listener
+func (m *Manager) SetUserBandwidth(username string, rate config.ByteSize) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    found := false
+    for _, l := range m.config.Listeners {
+        for _, u := range l.Users {
+            found = found || u.Username == username
+        }
+    }
+    if !found {
+        return fmt.Errorf("%w: %s", ErrUnknownUser, username)
+    }
+
+    ov := m.overrides.clone()
+    ov.userBandwidth[username] = rate
+    return m.apply(m.base, ov)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+    "errors"
+    "fmt"
+    "proxy-server/lockout"
+)
+
+// ErrUnknownBan: IP hoặc tài khoản không bị cấm
+var ErrUnknownBan = errors.New("no such ban")
+
+// Bans trả về các IP và tài khoản đang bị cấm vì xác thực sai nhiều lần
+func (m *Manager) Bans() []lockout.Ban {
+    return m.lockout.Bans()
+}
+
+// Unban gỡ lệnh cấm, kind là lockout.KindIP hoặc lockout.KindUser
+func (m *Manager) Unban(kind, key string) error {
+    if kind != lockout.KindIP && kind != lockout.KindUser {
+        return fmt.Errorf("%w: unknown ban kind %q (expected ip or user)", ErrInvalidConfig, kind)
+    }
+    if !m.lockout.Unban(kind, key) {
+        return fmt.Errorf("%w: %s %s", ErrUnknownBan, kind, key)
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "proxy-server/config"
+    "proxy-server/handler"
+    "proxy-server/socks5"
+    "sync"
+    "sync/atomic"
+)
+
+// protocolServer là phần chung của http.Server và socks5.Server
+type protocolServer interface {
+    Serve(ln net.Listener) error
+    Shutdown(ctx context.Context) error
+}
+
+// server là một socket đang lắng nghe của listener (HTTP hoặc SOCKS5)
+type server struct {
+    protocol string
+    address  string
+    srv      protocolServer
+    ln       net.Listener
+    // stopping được bật trước khi đóng socket để lỗi Accept không bị log như sự cố
+    stopping atomic.Bool
+}
+
+// stop đóng socket ngay để port được giải phóng, kết nối đang chạy không bị ảnh hưởng
+func (s *server) stop() {
+    s.stopping.Store(true)
+    s.ln.Close()
+}
+
+// proxyListener là một listener trong cấu hình: HTTP server và SOCKS5 server (nếu có)
+// dùng chung ProxyHandler. Khi reload handler được thay thế, request và tunnel
+// đang chạy vẫn dùng handler cũ tới khi kết thúc
+type proxyListener struct {
+    config  *config.ProxyConfig
+    handler atomic.Pointer[handler.ProxyHandler]
+    servers []*server
+
+    // requests đếm request HTTP và lệnh CONNECT SOCKS5 kể từ khi listener được mở
+    requests atomic.Int64
+    active   atomic.Int64
+}
+
+func (l *proxyListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    l.requests.Add(1)
+    l.active.Add(1)
+    defer l.active.Add(-1)
+
+    l.handler.Load().ServeHTTP(w, r)
+}
+
+func (l *proxyListener) AccessSOCKS(req *socks5.Request) socks5.Access {
+    return l.handler.Load().AccessSOCKS(req)
+}
+
+func (l *proxyListener) AuthenticateSOCKS(req *socks5.Request, username, password string) bool {
+    return l.handler.Load().AuthenticateSOCKS(req, username, password)
+}
+
+func (l *proxyListener) DialSOCKS(ctx context.Context, req *socks5.Request) (net.Conn, error) {
+    l.requests.Add(1)
+    return l.handler.Load().DialSOCKS(ctx, req)
+}
+
+func (l *proxyListener) RelaySOCKS(req *socks5.Request, client, dest net.Conn) {
+    l.active.Add(1)
+    defer l.active.Add(-1)
+
+    l.handler.Load().RelaySOCKS(req, client, dest)
+}
+
+// ListenerStatus là trạng thái và thống kê của một listener, trả về cho admin API
+type ListenerStatus struct {
+    Name           string   `json:"name"`
+    Mode           string   `json:"mode"`
+    Address        string   `json:"address"`
+    SocksAddress   string   `json:"socks_address,omitempty"`
+    Upstream       string   `json:"upstream,omitempty"`
+    Pool           string   `json:"pool,omitempty"`
+    Strategy       string   `json:"strategy,omitempty"`
+    RequireAuth    bool     `json:"require_auth"`
+    // Access là cách kết hợp quy tắc IP với xác thực: auth, ip, either hoặc both
+    Access         string   `json:"access"`
+    // ConnectPorts là port CONNECT được phép của listener, tài khoản có thể có danh sách riêng
+    ConnectPorts   []string `json:"connect_ports"`
+    Users          []string `json:"users"`
+    // Enabled = false khi listener bị tắt qua admin API
+    Enabled        bool     `json:"enabled"`
+    // Running = false khi listener bị tắt hoặc không mở được socket
+    Running        bool     `json:"running"`
+    Requests       int64    `json:"requests"`
+    ActiveRequests int64    `json:"active_requests"`
+    ActiveTunnels  int      `json:"active_tunnels"`
+}
+
+func newListenerStatus(proxyCfg *config.ProxyConfig) ListenerStatus {
+    status := ListenerStatus{
+        Name:        proxyCfg.Name,
+        Mode:        proxyCfg.Mode,
+        Address:     proxyCfg.GetServerAddress(),
+        Upstream:    proxyCfg.Upstream,
+        Pool:        proxyCfg.PoolName,
+        Strategy:    proxyCfg.Strategy,
+        RequireAuth: proxyCfg.RequireAuth,
+        Access:      proxyCfg.Access.Mode,
+        ConnectPorts: proxyCfg.AllowedConnectPorts(),
+        Users:       []string{},
+    }
+    if status.Access == "" {
+        status.Access = config.AccessAuth
+    }
+    if status.Mode == config.ModeSingle {
+        status.Mode = "single"
+    }
+    if proxyCfg.SocksPort != 0 {
+        status.SocksAddress = proxyCfg.GetSocksAddress()
+    }
+    for _, u := range proxyCfg.Users {
+        status.Users = append(status.Users, u.Username)
+    }
+    return status
+}
+
+// needsRestart cho biết thay đổi cấu hình có cần mở lại socket hay không,
+// các thay đổi khác (upstream, pool, tài khoản, auth và quy tắc IP) chỉ cần thay handler
+func needsRestart(old, new *config.ProxyConfig) bool {
+    return old.ServerHost != new.ServerHost ||
+        old.ServerPort != new.ServerPort ||
+        old.SocksPort != new.SocksPort
+}
+
+// onceCloseListener cho phép đóng socket nhiều lần, http.Server cũng đóng lại khi Shutdown
+type onceCloseListener struct {
+    net.Listener
+    once sync.Once
+    err  error
+}
+
+func (l *onceCloseListener) Close() error {
+    l.once.Do(func() { l.err = l.Listener.Close() })
+    return l.err
+}
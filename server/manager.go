@@ -0,0 +1,399 @@
+package server
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "proxy-server/accounting"
+    "proxy-server/acl"
+    "proxy-server/config"
+    "proxy-server/guard"
+    "proxy-server/handler"
+    "proxy-server/lockout"
+    "proxy-server/quota"
+    "proxy-server/socks5"
+    "proxy-server/throttle"
+    "proxy-server/upstream"
+    "proxy-server/utils"
+    "reflect"
+    "sync"
+
+    "go.uber.org/zap"
+)
+
+// Manager quản lý các listener đang chạy cùng registry và health checker dùng chung.
+// Reload và các thay đổi từ admin API được áp dụng mà không ngắt request hay tunnel đang chạy
+type Manager struct {
+    registry      *upstream.Registry
+    healthChecker *upstream.HealthChecker
+    tunnels       *handler.Tunnels
+    usage         *accounting.Ledger
+    quotas        *quota.Tracker
+    throttle      *throttle.Throttle
+    lockout       *lockout.Lockout
+    acl           *acl.ACL
+    guard         *guard.Guard
+
+    mu sync.Mutex
+    // base là cấu hình đọc từ file, config là cấu hình đang chạy (base + overrides)
+    base      *config.Config
+    config    *config.Config
+    overrides overrides
+    listeners map[string]*proxyListener
+    // draining là các listener đã bị gỡ, đang chờ kết nối cũ kết thúc
+    draining map[*proxyListener]struct{}
+    wg       sync.WaitGroup
+}
+
+// NewManager tạo registry và health checker, gọi Start để mở các listener.
+// usage ghi nhận lưu lượng theo tài khoản, nil = tắt thống kê.
+// quotas giữ lưu lượng đã dùng của quota, giới hạn được lấy từ cấu hình mỗi lần áp dụng
+func NewManager(cfg *config.Config, usage *accounting.Ledger, quotas *quota.Tracker) (*Manager, error) {
+    registry, err := upstream.NewRegistry(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Manager{
+        registry:      registry,
+        healthChecker: upstream.NewHealthChecker(registry, cfg.HealthCheck),
+        tunnels:       handler.NewTunnels(),
+        usage:         usage,
+        quotas:        quotas,
+        throttle:      throttle.New(),
+        lockout:       lockout.New(),
+        acl:           acl.New(),
+        guard:         guard.New(),
+        base:          cfg,
+        config:        cfg,
+        overrides:     newOverrides(),
+        listeners:     make(map[string]*proxyListener),
+        draining:      make(map[*proxyListener]struct{}),
+    }, nil
+}
+
+// Registry trả về registry upstream dùng chung
+func (m *Manager) Registry() *upstream.Registry {
+    return m.registry
+}
+
+// Tunnels trả về các tunnel CONNECT và SOCKS5 đang mở của mọi listener
+func (m *Manager) Tunnels() *handler.Tunnels {
+    return m.tunnels
+}
+
+// Usage trả về sổ lưu lượng theo tài khoản, nil nếu thống kê bị tắt
+func (m *Manager) Usage() *accounting.Ledger {
+    return m.usage
+}
+
+// Start bắt đầu health check và mở mọi listener trong cấu hình
+func (m *Manager) Start() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.healthChecker.Start()
+    m.setQuotaLimits(m.config)
+    m.setBandwidth(m.config)
+    m.lockout.Configure(m.config.Lockout)
+    m.acl.Configure(m.config.ACL, m.config.Groups)
+    m.guard.Configure(m.config.DestinationGuard)
+
+    var errs []error
+    options := m.handlerOptions(m.config)
+    for i := range m.config.Listeners {
+        proxyCfg := &m.config.Listeners[i]
+        if err := m.startListener(proxyCfg, options, m.config.Timeouts); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// Reload áp dụng cấu hình mới đọc từ file, các thay đổi từ admin API được giữ lại
+func (m *Manager) Reload(cfg *config.Config) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return m.apply(cfg, m.overrides)
+}
+
+// apply áp dụng overrides lên base rồi chuyển sang cấu hình kết quả:
+//   - listener mới được mở, listener bị xóa hoặc bị tắt ngừng nhận kết nối và chờ kết nối cũ kết thúc
+//   - listener đổi địa chỉ hay port được mở lại theo cách trên
+//   - listener còn lại chỉ được thay handler (upstream, pool, tài khoản, quy tắc IP)
+//
+// Nếu cấu hình kết quả không hợp lệ thì giữ nguyên trạng thái cũ
+func (m *Manager) apply(base *config.Config, ov overrides) error {
+    cfg := ov.apply(base)
+    if err := cfg.Check(); err != nil {
+        return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+    }
+    if err := m.registry.Update(cfg); err != nil {
+        return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+    }
+
+    if cfg.HealthCheck != m.config.HealthCheck {
+        m.healthChecker.Stop()
+        m.healthChecker = upstream.NewHealthChecker(m.registry, cfg.HealthCheck)
+        m.healthChecker.Start()
+    }
+    m.setQuotaLimits(cfg)
+    m.setBandwidth(cfg)
+    m.lockout.Configure(cfg.Lockout)
+    m.acl.Configure(cfg.ACL, cfg.Groups)
+    m.guard.Configure(cfg.DestinationGuard)
+
+    logger := utils.GetLogger()
+    options := m.handlerOptions(cfg)
+    optionsChanged := options != m.handlerOptions(m.config)
+    serverTimeoutsChanged := cfg.Timeouts.Read != m.config.Timeouts.Read ||
+        cfg.Timeouts.Write != m.config.Timeouts.Write ||
+        cfg.Timeouts.Idle != m.config.Timeouts.Idle
+
+    wanted := make(map[string]*config.ProxyConfig, len(cfg.Listeners))
+    for i := range cfg.Listeners {
+        if !ov.disabled[cfg.Listeners[i].Name] {
+            wanted[cfg.Listeners[i].Name] = &cfg.Listeners[i]
+        }
+    }
+
+    // Gỡ listener bị xóa hoặc cần mở lại trước để giải phóng port cho listener mới
+    for name, l := range m.listeners {
+        proxyCfg, ok := wanted[name]
+        if ok && !serverTimeoutsChanged && !needsRestart(l.config, proxyCfg) {
+            continue
+        }
+        switch {
+        case ok:
+            logger.Info("Restarting listener", zap.String("listener", name))
+        case ov.disabled[name]:
+            logger.Info("Disabling listener", zap.String("listener", name))
+        default:
+            logger.Info("Removing listener", zap.String("listener", name))
+        }
+        m.drain(l)
+        delete(m.listeners, name)
+    }
+
+    var errs []error
+    for i := range cfg.Listeners {
+        proxyCfg := &cfg.Listeners[i]
+        if wanted[proxyCfg.Name] == nil {
+            continue
+        }
+
+        if l, ok := m.listeners[proxyCfg.Name]; ok {
+            if !reflect.DeepEqual(l.config, proxyCfg) || optionsChanged {
+                logger.Info("Listener updated", zap.String("listener", proxyCfg.Name))
+            }
+            l.config = proxyCfg
+            l.handler.Store(handler.NewProxyHandler(proxyCfg, m.registry, options))
+            continue
+        }
+
+        if err := m.startListener(proxyCfg, options, cfg.Timeouts); err != nil {
+            errs = append(errs, err)
+        }
+    }
+
+    m.base = base
+    m.config = cfg
+    m.overrides = ov
+    return errors.Join(errs...)
+}
+
+// Listeners trả về trạng thái và thống kê của mọi listener trong cấu hình, kể cả listener bị tắt
+func (m *Manager) Listeners() []ListenerStatus {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    statuses := make([]ListenerStatus, 0, len(m.config.Listeners))
+    for i := range m.config.Listeners {
+        proxyCfg := &m.config.Listeners[i]
+
+        status := newListenerStatus(proxyCfg)
+        status.Enabled = !m.overrides.disabled[proxyCfg.Name]
+        if l, ok := m.listeners[proxyCfg.Name]; ok {
+            status.Running = true
+            status.Requests = l.requests.Load()
+            status.ActiveRequests = l.active.Load()
+        }
+        status.ActiveTunnels = m.tunnels.Count(proxyCfg.Name)
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
+
+// Shutdown dừng health check và mọi listener, kể cả listener đang drain.
+// Hết hạn ctx thì kết nối còn lại bị đóng cưỡng bức
+func (m *Manager) Shutdown(ctx context.Context) {
+    logger := utils.GetLogger()
+
+    m.mu.Lock()
+    m.healthChecker.Stop()
+
+    var servers []*server
+    for _, l := range m.listeners {
+        servers = append(servers, l.servers...)
+    }
+    for l := range m.draining {
+        servers = append(servers, l.servers...)
+    }
+    m.mu.Unlock()
+
+    var wg sync.WaitGroup
+    for _, s := range servers {
+        wg.Add(1)
+        go func(s *server) {
+            defer wg.Done()
+
+            logger.Info("Shutting down server",
+                zap.String("protocol", s.protocol),
+                zap.String("address", s.address))
+
+            s.stopping.Store(true)
+            if err := s.srv.Shutdown(ctx); err != nil {
+                logger.Error("Server shutdown failed",
+                    zap.String("protocol", s.protocol),
+                    zap.String("address", s.address),
+                    zap.Error(err))
+            }
+        }(s)
+    }
+    wg.Wait()
+    m.wg.Wait()
+}
+
+// startListener mở socket HTTP và SOCKS5 (nếu có) của listener, lỗi bind được trả về ngay
+func (m *Manager) startListener(proxyCfg *config.ProxyConfig, options handler.Options, timeouts config.TimeoutConfig) error {
+    l := &proxyListener{config: proxyCfg}
+    l.handler.Store(handler.NewProxyHandler(proxyCfg, m.registry, options))
+
+    httpServer := &http.Server{
+        Addr:         proxyCfg.GetServerAddress(),
+        Handler:      l,
+        ReadTimeout:  timeouts.Read,
+        WriteTimeout: timeouts.Write,
+        IdleTimeout:  timeouts.Idle,
+    }
+    l.servers = []*server{{protocol: "http", address: httpServer.Addr, srv: httpServer}}
+
+    if proxyCfg.SocksPort != 0 {
+        socksServer := &socks5.Server{
+            Addr:    proxyCfg.GetSocksAddress(),
+            Handler: l,
+        }
+        l.servers = append(l.servers, &server{protocol: "socks5", address: socksServer.Addr, srv: socksServer})
+    }
+
+    for i, s := range l.servers {
+        ln, err := net.Listen("tcp", s.address)
+        if err != nil {
+            for _, started := range l.servers[:i] {
+                started.stop()
+            }
+            return fmt.Errorf("listener %q: %w", proxyCfg.Name, err)
+        }
+        s.ln = &onceCloseListener{Listener: ln}
+    }
+
+    for _, s := range l.servers {
+        m.wg.Add(1)
+        go m.serve(proxyCfg, s)
+    }
+    m.listeners[proxyCfg.Name] = l
+    return nil
+}
+
+func (m *Manager) serve(proxyCfg *config.ProxyConfig, s *server) {
+    defer m.wg.Done()
+
+    logger := utils.GetLogger().With(
+        zap.String("listener", proxyCfg.Name),
+        zap.String("protocol", s.protocol),
+        zap.String("server_address", s.address),
+    )
+    switch proxyCfg.Mode {
+    case config.ModeGateway:
+        logger = logger.With(zap.String("mode", proxyCfg.Mode))
+    case config.ModePool:
+        logger = logger.With(
+            zap.String("mode", proxyCfg.Mode),
+            zap.String("pool", proxyCfg.PoolName),
+            zap.String("strategy", proxyCfg.Strategy),
+        )
+    default:
+        logger = logger.With(zap.String("upstream", proxyCfg.Upstream))
+    }
+
+    logger.Info("Starting proxy server")
+
+    err := s.srv.Serve(s.ln)
+    if err != nil && !s.stopping.Load() && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, socks5.ErrServerClosed) {
+        logger.Error("Server failed", zap.Error(err))
+    }
+}
+
+// drain đóng socket của listener ngay, sau đó chờ kết nối đang chạy kết thúc trong background.
+// Tunnel CONNECT đã hijack và tunnel SOCKS5 không bị giới hạn thời gian
+func (m *Manager) drain(l *proxyListener) {
+    for _, s := range l.servers {
+        s.stop()
+    }
+    m.draining[l] = struct{}{}
+    name := l.config.Name
+
+    m.wg.Add(1)
+    go func() {
+        defer m.wg.Done()
+
+        for _, s := range l.servers {
+            s.srv.Shutdown(context.Background())
+        }
+
+        m.mu.Lock()
+        delete(m.draining, l)
+        m.mu.Unlock()
+
+        utils.GetLogger().Info("Listener drained", zap.String("listener", name))
+    }()
+}
+
+func (m *Manager) handlerOptions(cfg *config.Config) handler.Options {
+    return handler.Options{
+        Retry:       cfg.Retry,
+        DialTimeout: cfg.Timeouts.Dial,
+        Tunnels:     m.tunnels,
+        Usage:       m.usage,
+        Quotas:      m.quotas,
+        Throttle:    m.throttle,
+        Lockout:     m.lockout,
+        ACL:         m.acl,
+        Guard:       m.guard,
+    }
+}
+
+// setBandwidth cập nhật giới hạn băng thông, áp dụng ngay cho cả tunnel đang mở
+func (m *Manager) setBandwidth(cfg *config.Config) {
+    listeners := make(map[string]int64)
+    for _, l := range cfg.Listeners {
+        if l.Bandwidth > 0 {
+            listeners[l.Name] = int64(l.Bandwidth)
+        }
+    }
+    users := make(map[string]int64)
+    for username, rate := range cfg.UserBandwidths() {
+        users[username] = int64(rate)
+    }
+    m.throttle.Configure(int64(cfg.Bandwidth), listeners, users)
+}
+
+// setQuotaLimits cập nhật giới hạn quota theo tài khoản của các listener trong cfg
+func (m *Manager) setQuotaLimits(cfg *config.Config) {
+    if m.quotas != nil {
+        m.quotas.SetLimits(cfg.QuotaLimits())
+    }
+}
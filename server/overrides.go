@@ -0,0 +1,230 @@
+package server
+
+import (
+    "errors"
+    "fmt"
+    "proxy-server/config"
+    "proxy-server/upstream"
+    "strconv"
+)
+
+var (
+    // ErrUnknownListener: không có listener với tên hoặc port yêu cầu
+    ErrUnknownListener = errors.New("unknown listener")
+    // ErrUnknownUser: listener không có tài khoản yêu cầu
+    ErrUnknownUser = errors.New("unknown user")
+    // ErrUpstreamExists: đã có upstream cùng tên
+    ErrUpstreamExists = errors.New("upstream already exists")
+    // ErrInvalidConfig: thay đổi làm cấu hình không hợp lệ, cấu hình cũ được giữ nguyên
+    ErrInvalidConfig = errors.New("invalid configuration")
+)
+
+// overrides là các thay đổi từ admin API. Chúng chỉ nằm trong bộ nhớ và được
+// áp dụng lại lên cấu hình đọc từ file sau mỗi lần reload
+type overrides struct {
+    // added là các upstream thêm qua admin API
+    added []config.UpstreamConfig
+    // removed là tên các upstream trong file cấu hình đã bị xóa
+    removed map[string]bool
+    // disabled là các listener bị tắt
+    disabled map[string]bool
+    // passwords[listener][username] là mật khẩu mới của tài khoản trên listener
+    passwords map[string]map[string]string
+    // globalBandwidth là giới hạn băng thông toàn cục, nil = dùng giá trị trong file
+    globalBandwidth   *config.ByteSize
+    listenerBandwidth map[string]config.ByteSize
+    userBandwidth     map[string]config.ByteSize
+}
+
+func newOverrides() overrides {
+    return overrides{
+        removed:           make(map[string]bool),
+        disabled:          make(map[string]bool),
+        passwords:         make(map[string]map[string]string),
+        listenerBandwidth: make(map[string]config.ByteSize),
+        userBandwidth:     make(map[string]config.ByteSize),
+    }
+}
+
+func (o overrides) clone() overrides {
+    clone := newOverrides()
+    clone.added = append(clone.added, o.added...)
+    for name := range o.removed {
+        clone.removed[name] = true
+    }
+    for name := range o.disabled {
+        clone.disabled[name] = true
+    }
+    for listener, users := range o.passwords {
+        clone.passwords[listener] = make(map[string]string, len(users))
+        for username, password := range users {
+            clone.passwords[listener][username] = password
+        }
+    }
+    clone.globalBandwidth = o.globalBandwidth
+    for name, rate := range o.listenerBandwidth {
+        clone.listenerBandwidth[name] = rate
+    }
+    for username, rate := range o.userBandwidth {
+        clone.userBandwidth[username] = rate
+    }
+    return clone
+}
+
+// apply trả về bản sao của base đã áp dụng các thay đổi. Listener single gắn với
+// upstream bị xóa cũng bị gỡ, listener bị tắt vẫn nằm trong cấu hình
+func (o overrides) apply(base *config.Config) *config.Config {
+    cfg := base.Clone()
+    if o.globalBandwidth != nil {
+        cfg.Bandwidth = *o.globalBandwidth
+    }
+
+    upstreams := cfg.Upstreams[:0]
+    for _, u := range cfg.Upstreams {
+        if !o.removed[u.Name] {
+            upstreams = append(upstreams, u)
+        }
+    }
+    cfg.Upstreams = append(upstreams, o.added...)
+
+    listeners := cfg.Listeners[:0]
+    for _, l := range cfg.Listeners {
+        if l.Mode == config.ModeSingle && o.removed[l.Upstream] {
+            continue
+        }
+        for i := range l.Users {
+            if password, ok := o.passwords[l.Name][l.Users[i].Username]; ok {
+                l.Users[i].Password = password
+                l.Users[i].PasswordHash = ""
+            }
+            if rate, ok := o.userBandwidth[l.Users[i].Username]; ok {
+                l.Users[i].Bandwidth = rate
+            }
+        }
+        if rate, ok := o.listenerBandwidth[l.Name]; ok {
+            l.Bandwidth = rate
+        }
+        listeners = append(listeners, l)
+    }
+    cfg.Listeners = listeners
+    return cfg
+}
+
+// AddUpstream thêm upstream mà không cần sửa file cấu hình, tên rỗng = host:port
+func (m *Manager) AddUpstream(u config.UpstreamConfig) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if u.Name == "" {
+        u.Name = u.GetProxyAddress()
+    }
+    if u.Pool != "" {
+        if err := config.CheckPoolName(u.Pool); err != nil {
+            return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+        }
+    }
+    if _, ok := m.config.Upstream(u.Name); ok {
+        return fmt.Errorf("%w: %s", ErrUpstreamExists, u.Name)
+    }
+
+    ov := m.overrides.clone()
+    ov.added = append(ov.added, u)
+    return m.apply(m.base, ov)
+}
+
+// RemoveUpstream xóa upstream, listener single gắn với upstream này cũng bị gỡ
+func (m *Manager) RemoveUpstream(name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if _, ok := m.config.Upstream(name); !ok {
+        return fmt.Errorf("%w: %s", upstream.ErrUnknownUpstream, name)
+    }
+
+    ov := m.overrides.clone()
+    added := ov.added[:0]
+    for _, u := range ov.added {
+        if u.Name != name {
+            added = append(added, u)
+        }
+    }
+    if len(added) == len(ov.added) {
+        ov.removed[name] = true
+    }
+    ov.added = added
+    return m.apply(m.base, ov)
+}
+
+// SetListenerEnabled bật hoặc tắt listener theo tên hoặc port. Tắt listener
+// ngừng nhận kết nối mới, kết nối đang chạy không bị ảnh hưởng
+func (m *Manager) SetListenerEnabled(name string, enabled bool) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    l, err := m.findListener(name)
+    if err != nil {
+        return err
+    }
+
+    ov := m.overrides.clone()
+    if enabled {
+        delete(ov.disabled, l.Name)
+    } else {
+        ov.disabled[l.Name] = true
+    }
+    return m.apply(m.base, ov)
+}
+
+// RotateCredentials đổi mật khẩu của tài khoản trên listener. username rỗng chỉ hợp lệ
+// khi listener có một tài khoản, password rỗng thì mật khẩu được sinh ngẫu nhiên
+func (m *Manager) RotateCredentials(listener, username, password string) (config.UserConfig, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    l, err := m.findListener(listener)
+    if err != nil {
+        return config.UserConfig{}, err
+    }
+
+    if username == "" {
+        if len(l.Users) != 1 {
+            return config.UserConfig{}, fmt.Errorf("%w: listener %s has %d users, username is required", ErrInvalidConfig, l.Name, len(l.Users))
+        }
+        username = l.Users[0].Username
+    }
+    found := false
+    for _, u := range l.Users {
+        found = found || u.Username == username
+    }
+    if !found {
+        return config.UserConfig{}, fmt.Errorf("%w: %s on listener %s", ErrUnknownUser, username, l.Name)
+    }
+
+    if password == "" {
+        if password, err = config.GeneratePassword(); err != nil {
+            return config.UserConfig{}, err
+        }
+    }
+
+    ov := m.overrides.clone()
+    if ov.passwords[l.Name] == nil {
+        ov.passwords[l.Name] = make(map[string]string)
+    }
+    ov.passwords[l.Name][username] = password
+    if err := m.apply(m.base, ov); err != nil {
+        return config.UserConfig{}, err
+    }
+    return config.UserConfig{Username: username, Password: password}, nil
+}
+
+// findListener tìm listener theo tên, hoặc theo HTTP port nếu name là số
+func (m *Manager) findListener(name string) (*config.ProxyConfig, error) {
+    port, _ := strconv.Atoi(name)
+    for i := range m.config.Listeners {
+        l := &m.config.Listeners[i]
+        if l.Name == name || port != 0 && l.ServerPort == port {
+            return l, nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %s", ErrUnknownListener, name)
+}
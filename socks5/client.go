@@ -0,0 +1,147 @@
+package socks5
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "time"
+)
+
+// Dialer kết nối tới destination thông qua SOCKS5 proxy
+type Dialer struct {
+    ProxyAddr string
+    Username  string
+    Password  string
+    // RemoteDNS gửi domain cho proxy tự resolve (socks5h), nếu false thì resolve ở local
+    RemoteDNS bool
+    Forward   *net.Dialer
+}
+
+// DialContext mở kết nối TCP tới addr qua SOCKS5 proxy
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    host, portStr, err := net.SplitHostPort(addr)
+    if err != nil {
+        return nil, err
+    }
+    port, err := strconv.Atoi(portStr)
+    if err != nil || port < 1 || port > 65535 {
+        return nil, fmt.Errorf("socks5: invalid port in %q", addr)
+    }
+
+    if !d.RemoteDNS && net.ParseIP(host) == nil {
+        ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+        if err != nil {
+            return nil, err
+        }
+        if len(ips) == 0 {
+            return nil, fmt.Errorf("socks5: no addresses for %s", host)
+        }
+        host = ips[0].IP.String()
+    }
+
+    forward := d.Forward
+    if forward == nil {
+        forward = &net.Dialer{Timeout: 30 * time.Second}
+    }
+
+    conn, err := forward.DialContext(ctx, "tcp", d.ProxyAddr)
+    if err != nil {
+        return nil, fmt.Errorf("dial socks5 proxy %s: %w", d.ProxyAddr, err)
+    }
+
+    // Giới hạn thời gian handshake theo context
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    } else if forward.Timeout > 0 {
+        conn.SetDeadline(time.Now().Add(forward.Timeout))
+    }
+
+    if err := d.handshake(conn, host, port); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    conn.SetDeadline(time.Time{})
+    return conn, nil
+}
+
+func (d *Dialer) handshake(conn net.Conn, host string, port int) error {
+    // Greeting: chỉ đề nghị user/pass khi có credentials
+    methods := []byte{MethodNoAuth}
+    if d.Username != "" || d.Password != "" {
+        methods = []byte{MethodNoAuth, MethodUserPass}
+    }
+    greeting := append([]byte{Version, byte(len(methods))}, methods...)
+    if _, err := conn.Write(greeting); err != nil {
+        return err
+    }
+
+    var choice [2]byte
+    if _, err := io.ReadFull(conn, choice[:]); err != nil {
+        return err
+    }
+    if choice[0] != Version {
+        return fmt.Errorf("socks5: unexpected protocol version %d", choice[0])
+    }
+
+    switch choice[1] {
+    case MethodNoAuth:
+    case MethodUserPass:
+        if err := d.authenticate(conn); err != nil {
+            return err
+        }
+    case MethodNoAcceptable:
+        return fmt.Errorf("socks5: no acceptable authentication method")
+    default:
+        return fmt.Errorf("socks5: unsupported authentication method %d", choice[1])
+    }
+
+    req, err := appendAddr([]byte{Version, CmdConnect, 0x00}, host, port)
+    if err != nil {
+        return err
+    }
+    if _, err := conn.Write(req); err != nil {
+        return err
+    }
+
+    var reply [3]byte
+    if _, err := io.ReadFull(conn, reply[:]); err != nil {
+        return err
+    }
+    if reply[0] != Version {
+        return fmt.Errorf("socks5: unexpected protocol version %d", reply[0])
+    }
+    if reply[1] != ReplySucceeded {
+        return &ReplyError{Code: reply[1]}
+    }
+
+    // Bỏ qua BND.ADDR/BND.PORT
+    _, err = readAddr(conn)
+    return err
+}
+
+// authenticate thực hiện username/password auth theo RFC 1929
+func (d *Dialer) authenticate(conn net.Conn) error {
+    if len(d.Username) > 255 || len(d.Password) > 255 {
+        return fmt.Errorf("socks5: username or password too long")
+    }
+
+    req := []byte{userPassVersion, byte(len(d.Username))}
+    req = append(req, d.Username...)
+    req = append(req, byte(len(d.Password)))
+    req = append(req, d.Password...)
+    if _, err := conn.Write(req); err != nil {
+        return err
+    }
+
+    var resp [2]byte
+    if _, err := io.ReadFull(conn, resp[:]); err != nil {
+        return err
+    }
+    if resp[1] != authSuccess {
+        return ErrAuthFailed
+    }
+    return nil
+}
@@ -0,0 +1,343 @@
+package socks5
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "proxy-server/utils"
+    "slices"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// ErrServerClosed được trả về bởi ListenAndServe sau khi gọi Shutdown
+var ErrServerClosed = errors.New("socks5: Server closed")
+
+// handshakeTimeout giới hạn thời gian greeting, auth và request của client
+const handshakeTimeout = 30 * time.Second
+
+// Request mô tả một lệnh CONNECT của client
+type Request struct {
+    RemoteAddr net.Addr
+    Username   string
+    DestAddr   string
+}
+
+// Access là quyết định của Handler cho địa chỉ client trước khi chọn phương thức auth
+type Access int
+
+const (
+    // AccessUserPass: client phải xác thực bằng username/password
+    AccessUserPass Access = iota
+    // AccessNoAuth: client được dùng server không cần xác thực
+    AccessNoAuth
+    // AccessDenied: đóng kết nối ngay sau greeting
+    AccessDenied
+)
+
+// Handler xử lý phần nghiệp vụ của SOCKS5 server: auth, dial và relay dữ liệu
+type Handler interface {
+    // AccessSOCKS quyết định phương thức auth theo địa chỉ client (req.RemoteAddr)
+    AccessSOCKS(req *Request) Access
+    // AuthenticateSOCKS kiểm tra username/password của client
+    AuthenticateSOCKS(req *Request, username, password string) bool
+    // DialSOCKS mở kết nối tới destination, trả về *ReplyError để chọn reply code
+    DialSOCKS(ctx context.Context, req *Request) (net.Conn, error)
+    // RelaySOCKS chuyển dữ liệu hai chiều, trả về khi tunnel đóng
+    RelaySOCKS(req *Request, client, dest net.Conn)
+}
+
+// Server là SOCKS5 server chỉ hỗ trợ lệnh CONNECT
+type Server struct {
+    Addr    string
+    Handler Handler
+
+    mu         sync.Mutex
+    listener   net.Listener
+    conns      map[net.Conn]struct{}
+    inShutdown bool
+    wg         sync.WaitGroup
+}
+
+// ListenAndServe lắng nghe trên Addr và xử lý kết nối cho tới khi Shutdown
+func (s *Server) ListenAndServe() error {
+    ln, err := net.Listen("tcp", s.Addr)
+    if err != nil {
+        return err
+    }
+    return s.Serve(ln)
+}
+
+// Serve nhận kết nối từ listener
+func (s *Server) Serve(ln net.Listener) error {
+    s.mu.Lock()
+    if s.inShutdown {
+        s.mu.Unlock()
+        ln.Close()
+        return ErrServerClosed
+    }
+    s.listener = ln
+    s.conns = make(map[net.Conn]struct{})
+    s.mu.Unlock()
+
+    for {
+        conn, err := ln.Accept()
+        if err != nil {
+            s.mu.Lock()
+            closed := s.inShutdown
+            s.mu.Unlock()
+            if closed {
+                return ErrServerClosed
+            }
+
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Timeout() {
+                time.Sleep(50 * time.Millisecond)
+                continue
+            }
+            return err
+        }
+
+        if !s.trackConn(conn) {
+            conn.Close()
+            continue
+        }
+
+        go func() {
+            defer s.untrackConn(conn)
+            s.serveConn(conn)
+        }()
+    }
+}
+
+// Shutdown đóng listener và chờ các tunnel đang mở kết thúc,
+// hết hạn ctx thì đóng cưỡng bức các kết nối còn lại
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.mu.Lock()
+    s.inShutdown = true
+    if s.listener != nil {
+        s.listener.Close()
+    }
+    s.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        s.mu.Lock()
+        for conn := range s.conns {
+            conn.Close()
+        }
+        s.mu.Unlock()
+        return ctx.Err()
+    }
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.inShutdown {
+        return false
+    }
+    s.conns[conn] = struct{}{}
+    s.wg.Add(1)
+    return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+    s.mu.Lock()
+    delete(s.conns, conn)
+    s.mu.Unlock()
+    s.wg.Done()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+    defer conn.Close()
+
+    logger := utils.GetLogger().With(
+        zap.String("protocol", "socks5"),
+        zap.String("server_address", s.Addr),
+        zap.String("remote_addr", conn.RemoteAddr().String()),
+    )
+
+    conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+    req := &Request{RemoteAddr: conn.RemoteAddr()}
+    if err := s.negotiate(conn, req); err != nil {
+        logger.Debug("SOCKS5 negotiation failed", zap.Error(err))
+        return
+    }
+
+    destAddr, err := s.readRequest(conn)
+    if err != nil {
+        logger.Debug("Invalid SOCKS5 request", zap.Error(err))
+        var replyErr *ReplyError
+        if errors.As(err, &replyErr) {
+            writeReply(conn, replyErr.Code)
+        }
+        return
+    }
+    req.DestAddr = destAddr
+
+    logger = logger.With(zap.String("destination", destAddr))
+    logger.Info("Processing SOCKS5 CONNECT request")
+
+    ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+    destConn, err := s.Handler.DialSOCKS(ctx, req)
+    cancel()
+    if err != nil {
+        logger.Error("Failed to connect through upstream proxy", zap.Error(err))
+        writeReply(conn, replyCode(err))
+        return
+    }
+    defer destConn.Close()
+
+    if err := writeReply(conn, ReplySucceeded); err != nil {
+        logger.Error("Failed to write SOCKS5 reply", zap.Error(err))
+        return
+    }
+    conn.SetDeadline(time.Time{})
+
+    logger.Info("SOCKS5 tunnel established")
+    s.Handler.RelaySOCKS(req, conn, destConn)
+}
+
+// negotiate chọn phương thức auth và kiểm tra username/password (RFC 1929)
+func (s *Server) negotiate(conn net.Conn, req *Request) error {
+    var header [2]byte
+    if _, err := io.ReadFull(conn, header[:]); err != nil {
+        return err
+    }
+    if header[0] != Version {
+        return errors.New("socks5: unsupported protocol version")
+    }
+
+    methods := make([]byte, header[1])
+    if _, err := io.ReadFull(conn, methods); err != nil {
+        return err
+    }
+
+    // Client được cho qua theo IP dùng 0x00 nếu có đề nghị, client chỉ đề nghị
+    // username/password thì vẫn xác thực bình thường
+    var wanted byte = MethodNoAcceptable
+    switch s.Handler.AccessSOCKS(req) {
+    case AccessDenied:
+        conn.Write([]byte{Version, MethodNoAcceptable})
+        return ErrAccessDenied
+    case AccessNoAuth:
+        if slices.Contains(methods, MethodNoAuth) {
+            wanted = MethodNoAuth
+        } else if slices.Contains(methods, MethodUserPass) {
+            wanted = MethodUserPass
+        }
+    default:
+        if slices.Contains(methods, MethodUserPass) {
+            wanted = MethodUserPass
+        }
+    }
+    if wanted == MethodNoAcceptable {
+        conn.Write([]byte{Version, MethodNoAcceptable})
+        return errors.New("socks5: no acceptable authentication method")
+    }
+
+    if _, err := conn.Write([]byte{Version, wanted}); err != nil {
+        return err
+    }
+    if wanted == MethodNoAuth {
+        return nil
+    }
+
+    username, password, err := readUserPass(conn)
+    if err != nil {
+        return err
+    }
+    req.Username = username
+
+    if !s.Handler.AuthenticateSOCKS(req, username, password) {
+        conn.Write([]byte{userPassVersion, authFailure})
+        return ErrAuthFailed
+    }
+    _, err = conn.Write([]byte{userPassVersion, authSuccess})
+    return err
+}
+
+func readUserPass(r io.Reader) (string, string, error) {
+    var header [2]byte
+    if _, err := io.ReadFull(r, header[:]); err != nil {
+        return "", "", err
+    }
+    if header[0] != userPassVersion {
+        return "", "", errors.New("socks5: unsupported auth version")
+    }
+
+    username := make([]byte, header[1])
+    if _, err := io.ReadFull(r, username); err != nil {
+        return "", "", err
+    }
+
+    var length [1]byte
+    if _, err := io.ReadFull(r, length[:]); err != nil {
+        return "", "", err
+    }
+    password := make([]byte, length[0])
+    if _, err := io.ReadFull(r, password); err != nil {
+        return "", "", err
+    }
+
+    return string(username), string(password), nil
+}
+
+// readRequest đọc VER | CMD | RSV | ATYP | DST.ADDR | DST.PORT
+func (s *Server) readRequest(r io.Reader) (string, error) {
+    var header [3]byte
+    if _, err := io.ReadFull(r, header[:]); err != nil {
+        return "", err
+    }
+    if header[0] != Version {
+        return "", errors.New("socks5: unsupported protocol version")
+    }
+
+    addr, err := readAddr(r)
+    if err != nil {
+        return "", err
+    }
+    if header[1] != CmdConnect {
+        return "", &ReplyError{Code: ReplyCommandNotSupported}
+    }
+    return addr, nil
+}
+
+func writeReply(w io.Writer, code byte) error {
+    // BND.ADDR luôn là 0.0.0.0:0 vì client không cần tới nó
+    _, err := w.Write([]byte{Version, code, 0x00, AddrTypeIPv4, 0, 0, 0, 0, 0, 0})
+    return err
+}
+
+// replyCode chuyển lỗi dial thành reply code gửi cho client
+func replyCode(err error) byte {
+    var replyErr *ReplyError
+    if errors.As(err, &replyErr) {
+        return replyErr.Code
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return ReplyHostUnreachable
+    }
+
+    var opErr *net.OpError
+    if errors.As(err, &opErr) && opErr.Op == "dial" {
+        return ReplyConnectionRefused
+    }
+    return ReplyGeneralFailure
+}
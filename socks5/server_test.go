@@ -0,0 +1,74 @@
+package socks5
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "net"
+    "testing"
+)
+
+// testHandler trả về access cố định và chỉ chấp nhận alice/secret
+type testHandler struct {
+    access Access
+}
+
+func (h *testHandler) AccessSOCKS(req *Request) Access { return h.access }
+
+func (h *testHandler) AuthenticateSOCKS(req *Request, username, password string) bool {
+    return username == "alice" && password == "secret"
+}
+
+func (h *testHandler) DialSOCKS(ctx context.Context, req *Request) (net.Conn, error) {
+    return nil, errors.New("not used")
+}
+
+func (h *testHandler) RelaySOCKS(req *Request, client, dest net.Conn) {}
+
+// userPass là gói username/password RFC 1929 của alice
+var userPass = []byte{userPassVersion, 5, 'a', 'l', 'i', 'c', 'e', 6, 's', 'e', 'c', 'r', 'e', 't'}
+
+func TestNegotiate(t *testing.T) {
+    tests := []struct {
+        name    string
+        access  Access
+        methods []byte
+        auth    []byte
+        want    []byte
+        wantErr bool
+    }{
+        {"allowed ip no auth", AccessNoAuth, []byte{MethodNoAuth, MethodUserPass}, nil, []byte{Version, MethodNoAuth}, false},
+        {"allowed ip only user/pass", AccessNoAuth, []byte{MethodUserPass}, userPass,
+            []byte{Version, MethodUserPass, userPassVersion, authSuccess}, false},
+        {"allowed ip no common method", AccessNoAuth, []byte{0x01}, nil, []byte{Version, MethodNoAcceptable}, true},
+        {"auth required", AccessUserPass, []byte{MethodNoAuth, MethodUserPass}, userPass,
+            []byte{Version, MethodUserPass, userPassVersion, authSuccess}, false},
+        {"auth required no user/pass", AccessUserPass, []byte{MethodNoAuth}, nil, []byte{Version, MethodNoAcceptable}, true},
+        {"denied", AccessDenied, []byte{MethodNoAuth, MethodUserPass}, nil, []byte{Version, MethodNoAcceptable}, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            client, server := net.Pipe()
+            defer client.Close()
+
+            s := &Server{Handler: &testHandler{access: tt.access}}
+            errc := make(chan error, 1)
+            go func() {
+                errc <- s.negotiate(server, &Request{})
+                server.Close()
+            }()
+
+            greeting := append([]byte{Version, byte(len(tt.methods))}, tt.methods...)
+            go client.Write(append(greeting, tt.auth...))
+            got, _ := io.ReadAll(client)
+
+            if !bytes.Equal(got, tt.want) {
+                t.Errorf("reply = %v, want %v", got, tt.want)
+            }
+            if err := <-errc; (err != nil) != tt.wantErr {
+                t.Errorf("negotiate() = %v, want error %v", err, tt.wantErr)
+            }
+        })
+    }
+}
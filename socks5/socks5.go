@@ -0,0 +1,132 @@
+package socks5
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+)
+
+// Các hằng số của giao thức SOCKS5 (RFC 1928) và username/password auth (RFC 1929)
+const (
+    Version = 0x05
+
+    MethodNoAuth       = 0x00
+    MethodUserPass     = 0x02
+    MethodNoAcceptable = 0xff
+
+    userPassVersion = 0x01
+    authSuccess     = 0x00
+    authFailure     = 0x01
+
+    CmdConnect = 0x01
+
+    AddrTypeIPv4   = 0x01
+    AddrTypeDomain = 0x03
+    AddrTypeIPv6   = 0x04
+)
+
+// Reply codes trả về trong response của request
+const (
+    ReplySucceeded           = 0x00
+    ReplyGeneralFailure      = 0x01
+    ReplyNotAllowed          = 0x02
+    ReplyNetworkUnreachable  = 0x03
+    ReplyHostUnreachable     = 0x04
+    ReplyConnectionRefused   = 0x05
+    ReplyTTLExpired          = 0x06
+    ReplyCommandNotSupported = 0x07
+    ReplyAddrNotSupported    = 0x08
+)
+
+var replyMessages = map[byte]string{
+    ReplyGeneralFailure:      "general SOCKS server failure",
+    ReplyNotAllowed:          "connection not allowed by ruleset",
+    ReplyNetworkUnreachable:  "network unreachable",
+    ReplyHostUnreachable:     "host unreachable",
+    ReplyConnectionRefused:   "connection refused",
+    ReplyTTLExpired:          "TTL expired",
+    ReplyCommandNotSupported: "command not supported",
+    ReplyAddrNotSupported:    "address type not supported",
+}
+
+// ErrAuthFailed được trả về khi SOCKS5 server từ chối username/password
+var ErrAuthFailed = errors.New("socks5: authentication failed")
+
+// ErrAccessDenied được trả về khi server từ chối địa chỉ của client trước khi xác thực
+var ErrAccessDenied = errors.New("socks5: client address not allowed")
+
+// ReplyError được trả về khi SOCKS5 server trả reply code khác succeeded
+type ReplyError struct {
+    Code byte
+}
+
+func (e *ReplyError) Error() string {
+    if msg, ok := replyMessages[e.Code]; ok {
+        return "socks5: " + msg
+    }
+    return fmt.Sprintf("socks5: unknown reply code %d", e.Code)
+}
+
+// appendAddr ghi địa chỉ theo format ATYP | ADDR | PORT
+func appendAddr(buf []byte, host string, port int) ([]byte, error) {
+    if ip := net.ParseIP(host); ip != nil {
+        if ip4 := ip.To4(); ip4 != nil {
+            buf = append(buf, AddrTypeIPv4)
+            buf = append(buf, ip4...)
+        } else {
+            buf = append(buf, AddrTypeIPv6)
+            buf = append(buf, ip.To16()...)
+        }
+    } else {
+        if len(host) == 0 || len(host) > 255 {
+            return nil, fmt.Errorf("socks5: invalid host %q", host)
+        }
+        buf = append(buf, AddrTypeDomain, byte(len(host)))
+        buf = append(buf, host...)
+    }
+    return append(buf, byte(port>>8), byte(port)), nil
+}
+
+// readAddr đọc địa chỉ theo format ATYP | ADDR | PORT
+func readAddr(r io.Reader) (string, error) {
+    var atyp [1]byte
+    if _, err := io.ReadFull(r, atyp[:]); err != nil {
+        return "", err
+    }
+
+    var host string
+    switch atyp[0] {
+    case AddrTypeIPv4:
+        ip := make([]byte, net.IPv4len)
+        if _, err := io.ReadFull(r, ip); err != nil {
+            return "", err
+        }
+        host = net.IP(ip).String()
+    case AddrTypeIPv6:
+        ip := make([]byte, net.IPv6len)
+        if _, err := io.ReadFull(r, ip); err != nil {
+            return "", err
+        }
+        host = net.IP(ip).String()
+    case AddrTypeDomain:
+        var length [1]byte
+        if _, err := io.ReadFull(r, length[:]); err != nil {
+            return "", err
+        }
+        domain := make([]byte, length[0])
+        if _, err := io.ReadFull(r, domain); err != nil {
+            return "", err
+        }
+        host = string(domain)
+    default:
+        return "", &ReplyError{Code: ReplyAddrNotSupported}
+    }
+
+    var port [2]byte
+    if _, err := io.ReadFull(r, port[:]); err != nil {
+        return "", err
+    }
+    return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
@@ -0,0 +1,172 @@
+package throttle
+
+import (
+    "context"
+    "io"
+    "sync"
+
+    "golang.org/x/time/rate"
+)
+
+// Direction là chiều của dữ liệu đi qua proxy
+type Direction int
+
+const (
+    // Upload là dữ liệu client gửi lên
+    Upload Direction = iota
+    // Download là dữ liệu trả về client
+    Download
+)
+
+// Limiter giới hạn băng thông bằng token bucket, mỗi chiều một bucket cùng rate.
+// Rate đổi được khi đang chạy và áp dụng ngay cho các kết nối đang dùng limiter
+type Limiter struct {
+    buckets [2]*rate.Limiter
+}
+
+func newLimiter() *Limiter {
+    return &Limiter{buckets: [2]*rate.Limiter{
+        rate.NewLimiter(rate.Inf, 0),
+        rate.NewLimiter(rate.Inf, 0),
+    }}
+}
+
+// setRate đặt giới hạn byte/giây, 0 = không giới hạn. Burst bằng lượng dữ liệu của một giây
+func (l *Limiter) setRate(bytesPerSecond int64) {
+    for _, b := range l.buckets {
+        if bytesPerSecond <= 0 {
+            b.SetLimit(rate.Inf)
+            continue
+        }
+        b.SetBurst(int(bytesPerSecond))
+        b.SetLimit(rate.Limit(bytesPerSecond))
+    }
+}
+
+// wait chờ tới khi bucket của chiều dir có đủ n token, chia nhỏ n theo burst
+func (l *Limiter) wait(ctx context.Context, dir Direction, n int) error {
+    b := l.buckets[dir]
+    for n > 0 {
+        if b.Limit() == rate.Inf {
+            return nil
+        }
+
+        chunk := min(n, b.Burst())
+        if err := b.WaitN(ctx, chunk); err != nil {
+            // Rate vừa bị giảm, thử lại với burst mới
+            if chunk > b.Burst() {
+                continue
+            }
+            return err
+        }
+        n -= chunk
+    }
+    return nil
+}
+
+// Reader trả về r bị giới hạn bởi các limiter theo chiều dir, không có limiter thì trả về r
+func Reader(ctx context.Context, r io.Reader, dir Direction, limiters ...*Limiter) io.Reader {
+    if len(limiters) == 0 {
+        return r
+    }
+    return &reader{ctx: ctx, r: r, dir: dir, limiters: limiters}
+}
+
+// ReadCloser giống Reader, Close đóng rc
+func ReadCloser(ctx context.Context, rc io.ReadCloser, dir Direction, limiters ...*Limiter) io.ReadCloser {
+    if len(limiters) == 0 {
+        return rc
+    }
+    return struct {
+        io.Reader
+        io.Closer
+    }{Reader(ctx, rc, dir, limiters...), rc}
+}
+
+type reader struct {
+    ctx      context.Context
+    r        io.Reader
+    dir      Direction
+    limiters []*Limiter
+}
+
+// Read đọc trước rồi chờ token cho số byte đã đọc, tốc độ trung bình không vượt rate
+func (r *reader) Read(p []byte) (int, error) {
+    n, err := r.r.Read(p)
+    if n > 0 {
+        for _, l := range r.limiters {
+            if waitErr := l.wait(r.ctx, r.dir, n); waitErr != nil {
+                return n, waitErr
+            }
+        }
+    }
+    return n, err
+}
+
+// Throttle giữ limiter toàn cục, theo listener và theo tài khoản. Limiter được giữ lại
+// qua các lần đổi giới hạn để giới hạn mới áp dụng cho cả tunnel đang mở
+type Throttle struct {
+    global *Limiter
+
+    mu        sync.Mutex
+    listeners map[string]*Limiter
+    users     map[string]*Limiter
+}
+
+// New tạo Throttle chưa giới hạn gì
+func New() *Throttle {
+    return &Throttle{
+        global:    newLimiter(),
+        listeners: make(map[string]*Limiter),
+        users:     make(map[string]*Limiter),
+    }
+}
+
+// Configure đặt giới hạn byte/giây, listener và tài khoản không có trong map không bị giới hạn
+func (t *Throttle) Configure(global int64, listeners, users map[string]int64) {
+    t.global.setRate(global)
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    configure(t.listeners, listeners)
+    configure(t.users, users)
+}
+
+func configure(limiters map[string]*Limiter, rates map[string]int64) {
+    for name, l := range limiters {
+        if _, ok := rates[name]; !ok {
+            l.setRate(0)
+        }
+    }
+    for name, bytesPerSecond := range rates {
+        l, ok := limiters[name]
+        if !ok {
+            l = newLimiter()
+            limiters[name] = l
+        }
+        l.setRate(bytesPerSecond)
+    }
+}
+
+// Limiters trả về các limiter áp dụng cho kết nối của user trên listener, user rỗng
+// (listener không yêu cầu auth) chỉ chịu giới hạn toàn cục và của listener
+func (t *Throttle) Limiters(listener, user string) []*Limiter {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    limiters := []*Limiter{t.global, limiterFor(t.listeners, listener)}
+    if user != "" {
+        limiters = append(limiters, limiterFor(t.users, user))
+    }
+    return limiters
+}
+
+func limiterFor(limiters map[string]*Limiter, name string) *Limiter {
+    l, ok := limiters[name]
+    if !ok {
+        l = newLimiter()
+        limiters[name] = l
+    }
+    return l
+}
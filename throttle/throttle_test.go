@@ -0,0 +1,104 @@
+package throttle
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "testing"
+    "time"
+)
+
+// readTimed đọc n byte qua r bằng một lần Read lớn hơn burst, trả về thời gian đã chờ
+func readTimed(t *testing.T, r io.Reader, n int) time.Duration {
+    t.Helper()
+    start := time.Now()
+    buf := make([]byte, n)
+    if _, err := io.ReadFull(r, buf); err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    return time.Since(start)
+}
+
+func TestLimitersLowestRateWins(t *testing.T) {
+    th := New()
+    th.Configure(0, map[string]int64{"gateway": 1 << 20}, map[string]int64{"alice": 10000})
+
+    tests := []struct {
+        name    string
+        user    string
+        minWait time.Duration
+        maxWait time.Duration
+    }{
+        // 15000 byte: burst 10000 dùng ngay, 5000 byte còn lại chờ 0.5s
+        {"user limit", "alice", 400 * time.Millisecond, 2 * time.Second},
+        {"listener limit only", "bob", 0, 100 * time.Millisecond},
+        {"no user", "", 0, 100 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            limiters := th.Limiters("gateway", tt.user)
+            r := Reader(context.Background(), bytes.NewReader(make([]byte, 15000)), Download, limiters...)
+            if d := readTimed(t, r, 15000); d < tt.minWait || d > tt.maxWait {
+                t.Errorf("15000 bytes took %v, want between %v and %v", d, tt.minWait, tt.maxWait)
+            }
+        })
+    }
+}
+
+func TestDirectionsHaveSeparateBuckets(t *testing.T) {
+    th := New()
+    th.Configure(10000, nil, nil)
+    limiters := th.Limiters("port-3000", "")
+
+    up := Reader(context.Background(), bytes.NewReader(make([]byte, 10000)), Upload, limiters...)
+    down := Reader(context.Background(), bytes.NewReader(make([]byte, 10000)), Download, limiters...)
+    if d := readTimed(t, up, 10000) + readTimed(t, down, 10000); d > 100*time.Millisecond {
+        t.Errorf("one burst in each direction took %v, the upload used the download bucket", d)
+    }
+}
+
+func TestConfigureKeepsLimiters(t *testing.T) {
+    th := New()
+    th.Configure(0, nil, map[string]int64{"alice": 10000})
+    before := th.Limiters("port-3000", "alice")
+
+    // Tài khoản bị bỏ khỏi cấu hình không còn bị giới hạn, limiter cũ vẫn được dùng
+    th.Configure(0, nil, nil)
+    after := th.Limiters("port-3000", "alice")
+    if before[2] != after[2] {
+        t.Fatal("user limiter replaced on Configure, open tunnels would keep the old rate")
+    }
+    r := Reader(context.Background(), bytes.NewReader(make([]byte, 50000)), Upload, before...)
+    if d := readTimed(t, r, 50000); d > 100*time.Millisecond {
+        t.Errorf("removed limit still applied to an open tunnel: 50000 bytes took %v", d)
+    }
+}
+
+func TestReaderStopsOnCancel(t *testing.T) {
+    th := New()
+    th.Configure(1000, nil, nil)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    r := Reader(ctx, bytes.NewReader(make([]byte, 5000)), Upload, th.Limiters("port-3000", "")...)
+    go func() {
+        time.Sleep(50 * time.Millisecond)
+        cancel()
+    }()
+
+    start := time.Now()
+    _, err := io.ReadAll(r)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("ReadAll() = %v, want context.Canceled", err)
+    }
+    if d := time.Since(start); d > time.Second {
+        t.Errorf("canceled read returned after %v", d)
+    }
+}
+
+func TestNoLimitersReturnsReader(t *testing.T) {
+    src := bytes.NewReader(nil)
+    if r := Reader(context.Background(), src, Upload); r != src {
+        t.Error("Reader without limiters wrapped the reader")
+    }
+}
@@ -0,0 +1,236 @@
+package upstream
+
+import (
+    "errors"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// ErrCircuitOpen được trả về khi circuit breaker của upstream đang từ chối request
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// BreakerState là trạng thái của circuit breaker
+type BreakerState int
+
+const (
+    BreakerClosed BreakerState = iota
+    BreakerOpen
+    BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+    switch s {
+    case BreakerOpen:
+        return "open"
+    case BreakerHalfOpen:
+        return "half-open"
+    }
+    return "closed"
+}
+
+// breaker là circuit breaker của một upstream: mở khi lỗi liên tiếp vượt ngưỡng
+// hoặc tỉ lệ lỗi trong cửa sổ gần nhất quá cao, sau cooldown cho phép một số
+// request thử (half-open), thành công thì đóng lại còn lỗi thì mở tiếp
+type breaker struct {
+    name   string
+    config config.BreakerConfig
+    
+    mu          sync.Mutex
+    state       BreakerState
+    consecutive int
+    // window lưu kết quả các request gần nhất (true = lỗi), dạng ring buffer
+    window      []bool
+    windowPos   int
+    windowCount int
+    openedAt    time.Time
+    trials      int
+}
+
+func newBreaker(name string, cfg config.BreakerConfig) *breaker {
+    size := cfg.WindowSize
+    if size < 1 {
+        size = 1
+    }
+    return &breaker{
+        name:   name,
+        config: cfg,
+        window: make([]bool, size),
+    }
+}
+
+// State trả về trạng thái hiện tại
+func (b *breaker) State() BreakerState {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    return b.state
+}
+
+// available cho biết breaker có nhận request hay không, không chiếm lượt thử half-open
+func (b *breaker) available() bool {
+    if !b.config.Enabled {
+        return true
+    }
+    
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    switch b.state {
+    case BreakerOpen:
+        return time.Since(b.openedAt) >= b.config.Cooldown
+    case BreakerHalfOpen:
+        return b.trials < b.config.HalfOpenMaxRequests
+    }
+    return true
+}
+
+// allow kiểm tra trước mỗi request, half-open thì chiếm một lượt thử.
+// Mỗi lần allow thành công phải được kết thúc bằng success, failure hoặc release
+func (b *breaker) allow() error {
+    if !b.config.Enabled {
+        return nil
+    }
+    
+    b.mu.Lock()
+    from := b.state
+    
+    if b.state == BreakerOpen {
+        if time.Since(b.openedAt) < b.config.Cooldown {
+            b.mu.Unlock()
+            return ErrCircuitOpen
+        }
+        b.state = BreakerHalfOpen
+        b.trials = 0
+    }
+    
+    if b.state == BreakerHalfOpen {
+        if b.trials >= b.config.HalfOpenMaxRequests {
+            b.mu.Unlock()
+            b.notify(from, b.State())
+            return ErrCircuitOpen
+        }
+        b.trials++
+    }
+    
+    to := b.state
+    b.mu.Unlock()
+    b.notify(from, to)
+    return nil
+}
+
+// success ghi nhận request thành công
+func (b *breaker) success() {
+    if !b.config.Enabled {
+        return
+    }
+    
+    b.mu.Lock()
+    from := b.state
+    b.consecutive = 0
+    b.record(false)
+    if b.state == BreakerHalfOpen {
+        b.closeLocked()
+    }
+    to := b.state
+    b.mu.Unlock()
+    b.notify(from, to)
+}
+
+// failure ghi nhận request lỗi do upstream
+func (b *breaker) failure() {
+    if !b.config.Enabled {
+        return
+    }
+    
+    b.mu.Lock()
+    from := b.state
+    b.consecutive++
+    b.record(true)
+    
+    switch {
+    case b.state == BreakerHalfOpen:
+        b.openLocked()
+    case b.state == BreakerClosed && b.shouldOpen():
+        b.openLocked()
+    }
+    to := b.state
+    b.mu.Unlock()
+    b.notify(from, to)
+}
+
+// release trả lại lượt thử mà không tính là thành công hay lỗi (ví dụ client hủy request)
+func (b *breaker) release() {
+    if !b.config.Enabled {
+        return
+    }
+    
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    if b.state == BreakerHalfOpen && b.trials > 0 {
+        b.trials--
+    }
+}
+
+func (b *breaker) shouldOpen() bool {
+    if b.config.FailureThreshold > 0 && b.consecutive >= b.config.FailureThreshold {
+        return true
+    }
+    
+    if b.config.ErrorRate <= 0 || b.windowCount < b.config.MinRequests || b.windowCount == 0 {
+        return false
+    }
+    failures := 0
+    for i := 0; i < b.windowCount; i++ {
+        if b.window[i] {
+            failures++
+        }
+    }
+    return float64(failures)/float64(b.windowCount) >= b.config.ErrorRate
+}
+
+func (b *breaker) record(failed bool) {
+    b.window[b.windowPos] = failed
+    b.windowPos = (b.windowPos + 1) % len(b.window)
+    if b.windowCount < len(b.window) {
+        b.windowCount++
+    }
+}
+
+func (b *breaker) openLocked() {
+    b.state = BreakerOpen
+    b.openedAt = time.Now()
+    b.trials = 0
+}
+
+func (b *breaker) closeLocked() {
+    b.state = BreakerClosed
+    b.consecutive = 0
+    b.trials = 0
+    b.windowPos = 0
+    b.windowCount = 0
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+    if from == to {
+        return
+    }
+    
+    logger := utils.GetLogger().With(
+        zap.String("upstream", b.name),
+        zap.String("from", from.String()),
+        zap.String("to", to.String()),
+    )
+    switch to {
+    case BreakerOpen:
+        logger.Warn("Circuit breaker opened", zap.Duration("cooldown", b.config.Cooldown))
+    case BreakerHalfOpen:
+        logger.Info("Circuit breaker half-open, allowing trial requests")
+    default:
+        logger.Info("Circuit breaker closed")
+    }
+}
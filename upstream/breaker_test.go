@@ -0,0 +1,171 @@
+package upstream
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "proxy-server/config"
+    "proxy-server/socks5"
+    "proxy-server/utils"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+    utils.Logger = zap.NewNop()
+    os.Exit(m.Run())
+}
+
+func newTestBreaker(cfg config.BreakerConfig) *breaker {
+    cfg.Enabled = true
+    if cfg.Cooldown == 0 {
+        cfg.Cooldown = time.Minute
+    }
+    if cfg.HalfOpenMaxRequests == 0 {
+        cfg.HalfOpenMaxRequests = 1
+    }
+    return newBreaker("test", cfg)
+}
+
+// expire đưa breaker đang mở qua hết cooldown
+func (b *breaker) expire() {
+    b.mu.Lock()
+    b.openedAt = time.Now().Add(-b.config.Cooldown)
+    b.mu.Unlock()
+}
+
+func TestBreakerOpens(t *testing.T) {
+    tests := []struct {
+        name    string
+        cfg     config.BreakerConfig
+        results string // s = success, f = failure
+        want    BreakerState
+    }{
+        {"consecutive failures", config.BreakerConfig{FailureThreshold: 3}, "fff", BreakerOpen},
+        {"below threshold", config.BreakerConfig{FailureThreshold: 3}, "ff", BreakerClosed},
+        {"success resets consecutive", config.BreakerConfig{FailureThreshold: 3}, "ffsff", BreakerClosed},
+        {"error rate", config.BreakerConfig{ErrorRate: 0.5, WindowSize: 4, MinRequests: 4}, "sfsf", BreakerOpen},
+        {"error rate below min requests", config.BreakerConfig{ErrorRate: 0.5, WindowSize: 4, MinRequests: 4}, "ff", BreakerClosed},
+        {"error rate below limit", config.BreakerConfig{ErrorRate: 0.5, WindowSize: 4, MinRequests: 4}, "ssfs", BreakerClosed},
+        // Cửa sổ chỉ giữ 4 kết quả gần nhất, hai lỗi đầu đã bị đẩy ra
+        {"window drops old results", config.BreakerConfig{ErrorRate: 0.5, WindowSize: 4, MinRequests: 4}, "ffssss", BreakerClosed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            b := newTestBreaker(tt.cfg)
+            for _, r := range tt.results {
+                if err := b.allow(); err != nil {
+                    t.Fatalf("allow() = %v before the breaker opened", err)
+                }
+                if r == 'f' {
+                    b.failure()
+                } else {
+                    b.success()
+                }
+            }
+            if got := b.State(); got != tt.want {
+                t.Errorf("state after %s = %s, want %s", tt.results, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+    b := newTestBreaker(config.BreakerConfig{FailureThreshold: 1, HalfOpenMaxRequests: 2})
+    b.allow()
+    b.failure()
+
+    if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("allow() during cooldown = %v, want ErrCircuitOpen", err)
+    }
+    if b.available() {
+        t.Error("available() = true during cooldown")
+    }
+
+    b.expire()
+    if !b.available() {
+        t.Fatal("available() = false after cooldown")
+    }
+    for i := 0; i < 2; i++ {
+        if err := b.allow(); err != nil {
+            t.Fatalf("trial %d: allow() = %v", i+1, err)
+        }
+    }
+    if b.State() != BreakerHalfOpen {
+        t.Fatalf("state = %s, want half-open", b.State())
+    }
+    if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("third trial allowed with half_open_requests 2: %v", err)
+    }
+
+    // Request bị client hủy trả lại lượt thử mà không đóng hay mở breaker
+    b.release()
+    if b.State() != BreakerHalfOpen || b.allow() != nil {
+        t.Fatal("released trial was not returned")
+    }
+
+    b.failure()
+    if b.State() != BreakerOpen {
+        t.Fatalf("state after failed trial = %s, want open", b.State())
+    }
+
+    b.expire()
+    b.allow()
+    b.success()
+    if b.State() != BreakerClosed {
+        t.Fatalf("state after successful trial = %s, want closed", b.State())
+    }
+    // Đóng lại thì bộ đếm bắt đầu từ đầu
+    b.allow()
+    b.failure()
+    if b.State() != BreakerOpen {
+        t.Errorf("state = %s, want open after one failure with threshold 1", b.State())
+    }
+}
+
+func TestBreakerDisabled(t *testing.T) {
+    b := newBreaker("test", config.BreakerConfig{FailureThreshold: 1})
+    for i := 0; i < 5; i++ {
+        if err := b.allow(); err != nil {
+            t.Fatalf("allow() = %v with the breaker disabled", err)
+        }
+        b.failure()
+    }
+    if b.State() != BreakerClosed {
+        t.Errorf("state = %s, want closed", b.State())
+    }
+}
+
+func TestReportCountsOnlyUpstreamFaults(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want BreakerState
+    }{
+        {"success", nil, BreakerClosed},
+        {"connection refused", errors.New("dial tcp: connection refused"), BreakerOpen},
+        {"upstream rejects credentials", &StatusError{StatusCode: http.StatusProxyAuthRequired}, BreakerOpen},
+        {"destination unreachable", &StatusError{StatusCode: http.StatusBadGateway}, BreakerClosed},
+        {"socks5 general failure", &socks5.ReplyError{Code: socks5.ReplyGeneralFailure}, BreakerOpen},
+        {"socks5 host unreachable", &socks5.ReplyError{Code: socks5.ReplyHostUnreachable}, BreakerClosed},
+        {"client canceled", fmt.Errorf("proxy: %w", context.Canceled), BreakerClosed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            u, err := New(&config.UpstreamConfig{Name: "up", ProxyScheme: "http", ProxyHost: "127.0.0.1", ProxyPort: 8080},
+                config.TimeoutConfig{}, config.BreakerConfig{Enabled: true, FailureThreshold: 1, Cooldown: time.Minute, HalfOpenMaxRequests: 1})
+            if err != nil {
+                t.Fatal(err)
+            }
+            u.Allow()
+            u.Report(tt.err)
+            if got := u.BreakerState(); got != tt.want {
+                t.Errorf("state after %v = %s, want %s", tt.err, got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,168 @@
+package upstream
+
+import (
+    "bufio"
+    "context"
+    "encoding/base64"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "proxy-server/config"
+    "proxy-server/socks5"
+    "strings"
+    "time"
+)
+
+// Dialer mở kết nối TCP tới destination thông qua upstream proxy
+type Dialer interface {
+    DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// StatusError được trả về khi upstream từ chối CONNECT với status khác 2xx
+type StatusError struct {
+    StatusCode int
+    Status     string
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("upstream proxy responded %s", e.Status)
+}
+
+// NewDialer tạo dialer tương ứng với upstream trong cấu hình
+func NewDialer(cfg *config.UpstreamConfig, timeout time.Duration) (Dialer, error) {
+    if cfg.ProxyHost == "" || cfg.ProxyPort == 0 {
+        return nil, fmt.Errorf("upstream proxy address is not configured")
+    }
+
+    forward := &net.Dialer{
+        Timeout:   timeout,
+        KeepAlive: 30 * time.Second,
+    }
+    
+    switch cfg.ProxyScheme {
+    case "", "http":
+        return &httpConnectDialer{
+            proxyAddr: cfg.GetProxyAddress(),
+            username:  cfg.ProxyUser,
+            password:  cfg.ProxyPass,
+            dialer:    forward,
+        }, nil
+    case "socks5", "socks5h":
+        dialer := &socks5.Dialer{
+            ProxyAddr: cfg.GetProxyAddress(),
+            Username:  cfg.ProxyUser,
+            Password:  cfg.ProxyPass,
+            RemoteDNS: cfg.ProxyScheme == "socks5h",
+            Forward:   forward,
+        }
+        if dialer.RemoteDNS {
+            return dialer, nil
+        }
+        // socks5 phân giải DNS tại proxy nên dial tới địa chỉ guard đã kiểm tra
+        return &pinnedDialer{Dialer: dialer}, nil
+    }
+    
+    return nil, fmt.Errorf("unsupported upstream proxy scheme %q", cfg.ProxyScheme)
+}
+
+// pinnedKey là khóa context của địa chỉ đã được destination guard kiểm tra
+type pinnedKey struct{}
+
+type pinnedHost struct {
+    host string
+    ip   string
+}
+
+// WithPinnedHost ghi vào ctx địa chỉ ip đã kiểm tra của host. Upstream phân giải DNS tại proxy
+// (socks5) dial tới ip thay vì phân giải lại host, upstream socks5h và HTTP vẫn nhận host
+func WithPinnedHost(ctx context.Context, host, ip string) context.Context {
+    return context.WithValue(ctx, pinnedKey{}, pinnedHost{host: host, ip: ip})
+}
+
+// pinnedDialer thay host của addr bằng ip ghi trong context, dùng cho cả tunnel
+// và request HTTP/HTTPS qua Transport nên Host header và SNI vẫn là tên miền gốc
+type pinnedDialer struct {
+    Dialer
+}
+
+func (d *pinnedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    pinned, ok := ctx.Value(pinnedKey{}).(pinnedHost)
+    if host, port, err := net.SplitHostPort(addr); ok && err == nil && strings.EqualFold(host, pinned.host) {
+        addr = net.JoinHostPort(pinned.ip, port)
+    }
+    return d.Dialer.DialContext(ctx, network, addr)
+}
+
+// httpConnectDialer mở tunnel bằng lệnh CONNECT tới HTTP upstream proxy
+type httpConnectDialer struct {
+    proxyAddr string
+    username  string
+    password  string
+    dialer    *net.Dialer
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyAddr)
+    if err != nil {
+        return nil, fmt.Errorf("dial upstream proxy %s: %w", d.proxyAddr, err)
+    }
+
+    // Giới hạn thời gian handshake theo context (hoặc timeout mặc định)
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(d.dialer.Timeout)
+    }
+    conn.SetDeadline(deadline)
+
+    req := &http.Request{
+        Method: http.MethodConnect,
+        URL:    &url.URL{Opaque: addr},
+        Host:   addr,
+        Header: make(http.Header),
+    }
+    if d.username != "" || d.password != "" {
+        req.Header.Set("Proxy-Authorization", basicAuth(d.username, d.password))
+    }
+
+    if err := req.Write(conn); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("write CONNECT to upstream proxy: %w", err)
+    }
+
+    br := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(br, req)
+    if err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("read CONNECT response from upstream proxy: %w", err)
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        conn.Close()
+        return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+    }
+
+    // Bỏ deadline của handshake, tunnel tự quản lý timeout
+    conn.SetDeadline(time.Time{})
+
+    // Upstream có thể đã gửi kèm dữ liệu sau response header
+    if br.Buffered() > 0 {
+        return &bufferedConn{Conn: conn, reader: br}, nil
+    }
+    return conn, nil
+}
+
+func basicAuth(username, password string) string {
+    return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// bufferedConn đọc phần dữ liệu còn trong bufio.Reader trước khi đọc từ conn
+type bufferedConn struct {
+    net.Conn
+    reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+    return c.reader.Read(p)
+}
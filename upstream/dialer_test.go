@@ -0,0 +1,64 @@
+package upstream
+
+import (
+    "context"
+    "net"
+    "proxy-server/config"
+    "testing"
+)
+
+// recordDialer ghi lại địa chỉ được dial thay vì mở kết nối
+type recordDialer struct {
+    addr string
+}
+
+func (d *recordDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    d.addr = addr
+    return nil, nil
+}
+
+func TestPinnedDialer(t *testing.T) {
+    pinned := WithPinnedHost(context.Background(), "example.com", "93.184.216.34")
+
+    tests := []struct {
+        name string
+        ctx  context.Context
+        addr string
+        want string
+    }{
+        {"pinned host", pinned, "example.com:443", "93.184.216.34:443"},
+        {"keeps port of the dial", pinned, "example.com:80", "93.184.216.34:80"},
+        {"host is case insensitive", pinned, "Example.COM:443", "93.184.216.34:443"},
+        {"other host is not pinned", pinned, "other.example.com:443", "other.example.com:443"},
+        {"no pin in context", context.Background(), "example.com:443", "example.com:443"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            inner := &recordDialer{}
+            (&pinnedDialer{Dialer: inner}).DialContext(tt.ctx, "tcp", tt.addr)
+            if inner.addr != tt.want {
+                t.Errorf("dialed %q, want %q", inner.addr, tt.want)
+            }
+        })
+    }
+}
+
+func TestNewDialerPinsOnlyLocalDNS(t *testing.T) {
+    tests := []struct {
+        scheme string
+        pinned bool
+    }{
+        {"socks5", true},
+        {"socks5h", false},
+        {"http", false},
+    }
+    for _, tt := range tests {
+        d, err := NewDialer(&config.UpstreamConfig{ProxyScheme: tt.scheme, ProxyHost: "127.0.0.1", ProxyPort: 1080}, 0)
+        if err != nil {
+            t.Fatalf("NewDialer(%s): %v", tt.scheme, err)
+        }
+        if _, ok := d.(*pinnedDialer); ok != tt.pinned {
+            t.Errorf("NewDialer(%s) pinned = %v, want %v", tt.scheme, ok, tt.pinned)
+        }
+    }
+}
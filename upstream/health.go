@@ -0,0 +1,236 @@
+package upstream
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// healthCheckConcurrency giới hạn số upstream được kiểm tra cùng lúc
+const healthCheckConcurrency = 16
+
+// healthState là trạng thái health check của một upstream
+type healthState struct {
+    healthy   bool
+    successes int
+    failures  int
+    lastCheck time.Time
+    lastError string
+}
+
+// HealthStatus là snapshot trạng thái health của upstream
+type HealthStatus struct {
+    Healthy   bool      `json:"healthy"`
+    // CheckHealthy là kết quả của health check, chưa tính circuit breaker
+    CheckHealthy bool   `json:"check_healthy"`
+    Breaker   string    `json:"breaker"`
+    LastCheck time.Time `json:"last_check"`
+    LastError string    `json:"last_error,omitempty"`
+}
+
+// HealthStatus trả về trạng thái health hiện tại
+func (u *Upstream) HealthStatus() HealthStatus {
+    u.stateMu.Lock()
+    health := u.health
+    u.stateMu.Unlock()
+    
+    return HealthStatus{
+        Healthy:      health.healthy && u.breaker.available(),
+        CheckHealthy: health.healthy,
+        Breaker:      u.breaker.State().String(),
+        LastCheck:    health.lastCheck,
+        LastError:    health.lastError,
+    }
+}
+
+// recordCheck cập nhật kết quả health check theo ngưỡng, trả về true nếu trạng thái thay đổi
+func (u *Upstream) recordCheck(err error, rise, fall int) bool {
+    u.stateMu.Lock()
+    defer u.stateMu.Unlock()
+    
+    h := &u.health
+    h.lastCheck = time.Now()
+    
+    if err == nil {
+        h.lastError = ""
+        h.failures = 0
+        h.successes++
+        if !h.healthy && h.successes >= rise {
+            h.healthy = true
+            return true
+        }
+        return false
+    }
+    
+    h.lastError = err.Error()
+    h.successes = 0
+    h.failures++
+    if h.healthy && h.failures >= fall {
+        h.healthy = false
+        return true
+    }
+    return false
+}
+
+// HealthChecker kiểm tra định kỳ tất cả upstream trong registry
+type HealthChecker struct {
+    registry *Registry
+    config   config.HealthCheckConfig
+    
+    cancel context.CancelFunc
+    done   chan struct{}
+}
+
+// NewHealthChecker tạo health checker, gọi Start để bắt đầu kiểm tra
+func NewHealthChecker(registry *Registry, cfg config.HealthCheckConfig) *HealthChecker {
+    return &HealthChecker{
+        registry: registry,
+        config:   cfg,
+    }
+}
+
+// Start chạy health check trong background, không làm gì nếu Interval = 0
+func (c *HealthChecker) Start() {
+    if c.config.Interval <= 0 {
+        utils.GetLogger().Info("Upstream health check disabled")
+        return
+    }
+    
+    ctx, cancel := context.WithCancel(context.Background())
+    c.cancel = cancel
+    c.done = make(chan struct{})
+    
+    utils.GetLogger().Info("Starting upstream health check",
+        zap.Duration("interval", c.config.Interval),
+        zap.String("connect_target", c.config.ConnectTarget),
+        zap.String("url", utils.RedactURL(c.config.URL)),
+    )
+    
+    go func() {
+        defer close(c.done)
+        
+        ticker := time.NewTicker(c.config.Interval)
+        defer ticker.Stop()
+        
+        for {
+            c.checkAll(ctx)
+            
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+        }
+    }()
+}
+
+// Stop dừng health check và chờ lượt kiểm tra đang chạy kết thúc
+func (c *HealthChecker) Stop() {
+    if c.cancel == nil {
+        return
+    }
+    c.cancel()
+    <-c.done
+}
+
+func (c *HealthChecker) checkAll(ctx context.Context) {
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, healthCheckConcurrency)
+    
+    for _, u := range c.registry.All() {
+        select {
+        case sem <- struct{}{}:
+        case <-ctx.Done():
+            wg.Wait()
+            return
+        }
+        
+        wg.Add(1)
+        go func(u *Upstream) {
+            defer wg.Done()
+            defer func() { <-sem }()
+            
+            c.checkOne(ctx, u)
+        }(u)
+    }
+    
+    wg.Wait()
+}
+
+func (c *HealthChecker) checkOne(ctx context.Context, u *Upstream) {
+    ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    defer cancel()
+    
+    start := time.Now()
+    err := c.probe(ctx, u)
+    if ctx.Err() == context.Canceled {
+        return // Đang shutdown, không ghi nhận kết quả
+    }
+    
+    logger := utils.GetLogger().With(
+        zap.String("upstream", u.Name),
+        zap.String("proxy_address", u.Address()),
+    )
+    
+    if err != nil {
+        logger.Debug("Upstream health check failed", zap.Error(err))
+    } else {
+        logger.Debug("Upstream health check passed", zap.Duration("duration", time.Since(start)))
+    }
+    
+    if !u.recordCheck(err, c.config.HealthyThreshold, c.config.UnhealthyThreshold) {
+        return
+    }
+    
+    if err != nil {
+        logger.Warn("Upstream marked unhealthy", zap.Error(err))
+    } else {
+        logger.Info("Upstream marked healthy")
+    }
+}
+
+// probe kiểm tra upstream theo ba bước: TCP connect, CONNECT handshake và HTTP GET (tùy chọn)
+func (c *HealthChecker) probe(ctx context.Context, u *Upstream) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", u.Address())
+    if err != nil {
+        return fmt.Errorf("tcp connect: %w", err)
+    }
+    conn.Close()
+    
+    if c.config.ConnectTarget != "" {
+        conn, err := u.dialer.DialContext(ctx, "tcp", c.config.ConnectTarget)
+        if err != nil {
+            return fmt.Errorf("connect handshake: %w", err)
+        }
+        conn.Close()
+    }
+    
+    if c.config.URL != "" {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.URL, nil)
+        if err != nil {
+            return fmt.Errorf("http get: %w", err)
+        }
+        
+        resp, err := u.Client.Do(req)
+        if err != nil {
+            return fmt.Errorf("http get: %w", err)
+        }
+        io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+        resp.Body.Close()
+        
+        if resp.StatusCode >= 500 {
+            return fmt.Errorf("http get: status %d", resp.StatusCode)
+        }
+    }
+    
+    return nil
+}
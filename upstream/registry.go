@@ -0,0 +1,327 @@
+package upstream
+
+import (
+    "errors"
+    "fmt"
+    "proxy-server/config"
+    "proxy-server/utils"
+    "sync"
+
+    "go.uber.org/zap"
+)
+
+var (
+    // ErrUnknownUpstream: không có upstream ứng với port trong selector
+    ErrUnknownUpstream = errors.New("unknown upstream")
+    // ErrUnknownPool: pool trong selector chưa được khai báo
+    ErrUnknownPool = errors.New("unknown pool")
+    // ErrNoUpstream: không còn upstream nào để chọn
+    ErrNoUpstream = errors.New("no upstream available")
+)
+
+// Registry quản lý toàn bộ upstream và pool, dùng chung cho mọi listener
+type Registry struct {
+    mu              sync.RWMutex
+    upstreams       []*Upstream
+    byName          map[string]*Upstream
+    byPort          map[int]*Upstream
+    pools           map[string][]*Upstream
+    defaultStrategy string
+    // poolStrategies là strategy khai báo riêng cho từng pool
+    poolStrategies  map[string]string
+    // strategies lưu trạng thái strategy (ví dụ bộ đếm round-robin) theo pool
+    strategies      map[string]Strategy
+    sessions        *sessionStore
+    // Cấu hình dùng để tạo upstream, thay đổi thì upstream được tạo lại khi reload
+    timeouts        config.TimeoutConfig
+    breakerConfig   config.BreakerConfig
+}
+
+// NewRegistry tạo registry từ cấu hình đã load
+func NewRegistry(cfg *config.Config) (*Registry, error) {
+    r := &Registry{
+        byName:     make(map[string]*Upstream),
+        strategies: make(map[string]Strategy),
+        sessions:   newSessionStore(cfg.SessionTTL),
+    }
+    if err := r.Update(cfg); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+// Update áp dụng cấu hình mới khi reload. Upstream không thay đổi được giữ nguyên cùng
+// trạng thái health, circuit breaker và latency. Upstream bị xóa không còn được chọn,
+// nhưng request và tunnel đang dùng chúng vẫn chạy tới khi kết thúc.
+// Cấu hình lỗi thì registry giữ nguyên trạng thái cũ
+func (r *Registry) Update(cfg *config.Config) error {
+    if !ValidStrategy(cfg.Strategy) {
+        return fmt.Errorf("unknown strategy %q", cfg.Strategy)
+    }
+    
+    r.mu.RLock()
+    current := r.byName
+    reusable := r.timeouts == cfg.Timeouts && r.breakerConfig == cfg.Breaker
+    r.mu.RUnlock()
+    
+    var (
+        upstreams []*Upstream
+        byName    = make(map[string]*Upstream)
+        byPort    = make(map[int]*Upstream)
+        pools     = make(map[string][]*Upstream)
+        added     []*Upstream
+    )
+    for i := range cfg.Upstreams {
+        upstreamCfg := &cfg.Upstreams[i]
+        
+        u, ok := current[upstreamCfg.Name]
+        if !ok || !reusable || *u.Config != *upstreamCfg {
+            var err error
+            u, err = New(upstreamCfg, cfg.Timeouts, cfg.Breaker)
+            if err != nil {
+                return fmt.Errorf("upstream %s: %w", upstreamCfg.Name, err)
+            }
+            added = append(added, u)
+        }
+        
+        upstreams = append(upstreams, u)
+        byName[u.Name] = u
+        if upstreamCfg.Pool != "" {
+            pools[upstreamCfg.Pool] = append(pools[upstreamCfg.Pool], u)
+        }
+    }
+    
+    poolStrategies := make(map[string]string)
+    for _, pool := range cfg.Pools {
+        if pool.Strategy == "" {
+            continue
+        }
+        if !ValidStrategy(pool.Strategy) {
+            return fmt.Errorf("pool %s: unknown strategy %q", pool.Name, pool.Strategy)
+        }
+        poolStrategies[pool.Name] = pool.Strategy
+    }
+    
+    // Selector theo port (user-3002) trỏ tới upstream của listener single tương ứng
+    for _, listener := range cfg.Listeners {
+        if listener.Strategy != "" && !ValidStrategy(listener.Strategy) {
+            return fmt.Errorf("listener %s: unknown strategy %q", listener.Name, listener.Strategy)
+        }
+        if listener.Mode == config.ModeSingle {
+            u, ok := byName[listener.Upstream]
+            if !ok {
+                return fmt.Errorf("listener %s: %w %q", listener.Name, ErrUnknownUpstream, listener.Upstream)
+            }
+            byPort[listener.ServerPort] = u
+        }
+    }
+    
+    r.mu.Lock()
+    previous := r.upstreams
+    r.upstreams = upstreams
+    r.byName = byName
+    r.byPort = byPort
+    r.pools = pools
+    r.defaultStrategy = cfg.Strategy
+    r.poolStrategies = poolStrategies
+    r.timeouts = cfg.Timeouts
+    r.breakerConfig = cfg.Breaker
+    r.mu.Unlock()
+    
+    r.sessions.setTTL(cfg.SessionTTL)
+    
+    if previous == nil {
+        return nil // Lần load đầu tiên, không có gì để so sánh
+    }
+    
+    logger := utils.GetLogger()
+    for _, u := range added {
+        if old, ok := current[u.Name]; ok {
+            old.Client.CloseIdleConnections()
+            logger.Info("Upstream updated", zap.String("upstream", u.Name), zap.String("proxy_address", u.Address()))
+        } else {
+            logger.Info("Upstream added", zap.String("upstream", u.Name), zap.String("proxy_address", u.Address()))
+        }
+    }
+    for _, u := range previous {
+        if _, ok := byName[u.Name]; !ok {
+            // Kết nối idle không còn dùng được nữa, kết nối đang chạy không bị ảnh hưởng
+            u.Client.CloseIdleConnections()
+            logger.Info("Upstream removed", zap.String("upstream", u.Name), zap.String("proxy_address", u.Address()))
+        }
+    }
+    return nil
+}
+
+// registered kiểm tra upstream còn nằm trong registry (chưa bị xóa hoặc thay thế khi reload)
+func (r *Registry) registered(u *Upstream) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    return r.byName[u.Name] == u
+}
+
+// ByName trả về upstream theo tên
+func (r *Registry) ByName(name string) (*Upstream, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    u, ok := r.byName[name]
+    if !ok {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownUpstream, name)
+    }
+    return u, nil
+}
+
+// ByPort trả về upstream của listener single theo port
+func (r *Registry) ByPort(port int) (*Upstream, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    u, ok := r.byPort[port]
+    if !ok {
+        return nil, fmt.Errorf("%w: port %d", ErrUnknownUpstream, port)
+    }
+    return u, nil
+}
+
+// All trả về tất cả upstream
+func (r *Registry) All() []*Upstream {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    return append([]*Upstream(nil), r.upstreams...)
+}
+
+// HasPool kiểm tra pool đã được khai báo chưa
+func (r *Registry) HasPool(name string) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    _, ok := r.pools[name]
+    return ok
+}
+
+// Select chọn upstream theo selector, bỏ qua các upstream trong exclude nếu còn lựa chọn khác.
+// Session giữ nguyên upstream đã chọn trước đó, nếu upstream đó unhealthy
+// hoặc nằm trong exclude thì session được chuyển sang upstream khác
+func (r *Registry) Select(sel Selector, exclude ...*Upstream) (*Upstream, error) {
+    var previous *Upstream
+    if sel.Session != "" {
+        if u, ok := r.sessions.get(sel.sessionKey()); ok {
+            if u.Healthy() && !contains(exclude, u) && r.registered(u) {
+                return u, nil
+            }
+            previous = u
+        }
+    }
+    
+    if previous != nil {
+        exclude = append(exclude, previous)
+    }
+    
+    u, err := r.pick(sel, exclude)
+    if err != nil {
+        return nil, err
+    }
+    
+    if sel.Session != "" {
+        r.sessions.set(sel.sessionKey(), u)
+        
+        logger := utils.GetLogger().With(
+            zap.String("session", sel.String()),
+            zap.String("listener", sel.Listener),
+            zap.String("user", sel.Account),
+            zap.String("upstream", u.Name),
+        )
+        if previous != nil {
+            logger.Warn("Session failed over to new upstream",
+                zap.String("previous_upstream", previous.Name))
+        } else {
+            logger.Info("Session assigned to upstream")
+        }
+    }
+    return u, nil
+}
+
+// pick chọn upstream healthy theo strategy và không nằm trong exclude.
+// Khi không còn lựa chọn nào thì nới dần điều kiện (bỏ healthy, rồi bỏ exclude)
+// thay vì từ chối request. Selector theo port luôn trả về upstream cố định
+func (r *Registry) pick(sel Selector, exclude []*Upstream) (*Upstream, error) {
+    if sel.Port != 0 {
+        return r.ByPort(sel.Port)
+    }
+    
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    candidates := r.upstreams
+    if sel.Pool != "" {
+        members, ok := r.pools[sel.Pool]
+        if !ok {
+            return nil, fmt.Errorf("%w: %s", ErrUnknownPool, sel.Pool)
+        }
+        candidates = members
+    }
+    
+    if len(candidates) == 0 {
+        return nil, ErrNoUpstream
+    }
+    
+    var healthy, untried []*Upstream
+    for _, u := range candidates {
+        if contains(exclude, u) {
+            continue
+        }
+        untried = append(untried, u)
+        if u.Healthy() {
+            healthy = append(healthy, u)
+        }
+    }
+    if len(healthy) > 0 {
+        candidates = healthy
+    } else if len(untried) > 0 {
+        candidates = untried
+    }
+    
+    strategy, err := r.strategyLocked(sel)
+    if err != nil {
+        return nil, err
+    }
+    return strategy.Pick(candidates), nil
+}
+
+// strategyLocked trả về strategy cho pool của selector, tạo mới nếu chưa có
+func (r *Registry) strategyLocked(sel Selector) (Strategy, error) {
+    name := sel.Strategy
+    if sel.Random {
+        name = StrategyRandom
+    }
+    if name == "" {
+        name = r.poolStrategies[sel.Pool]
+    }
+    if name == "" {
+        name = r.defaultStrategy
+    }
+    
+    key := sel.Pool + "/" + name
+    if s, ok := r.strategies[key]; ok {
+        return s, nil
+    }
+    
+    s, err := NewStrategy(name)
+    if err != nil {
+        return nil, err
+    }
+    r.strategies[key] = s
+    return s, nil
+}
+
+func contains(list []*Upstream, u *Upstream) bool {
+    for _, item := range list {
+        if item == u {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,93 @@
+package upstream
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// ErrInvalidSelector: phần selector trong username không đúng cú pháp
+var ErrInvalidSelector = errors.New("invalid upstream selector")
+
+// Selector mô tả cách chọn upstream, được parse từ phần sau username của gateway
+//
+//   3002           upstream của per-port listener 3002
+//   pool-us        một upstream trong pool "us"
+//   random         một upstream bất kỳ
+//   session-abc123 giữ nguyên upstream cho cùng session
+//
+// Các phần có thể kết hợp, ví dụ pool-us-session-abc123.
+type Selector struct {
+    Port    int
+    Pool    string
+    Random  bool
+    Session string
+    // Strategy chọn upstream trong pool, rỗng = strategy mặc định của registry
+    Strategy string
+    // Listener và Account giới hạn session trong một listener và một tài khoản,
+    // để tài khoản khác dùng cùng session id không lấy được upstream đã gán
+    Listener string
+    Account  string
+}
+
+// ParseSelector parse chuỗi selector, chuỗi rỗng tương đương random
+func ParseSelector(s string) (Selector, error) {
+    var sel Selector
+    if s == "" {
+        sel.Random = true
+        return sel, nil
+    }
+    
+    parts := strings.Split(s, "-")
+    for i := 0; i < len(parts); i++ {
+        switch part := parts[i]; part {
+        case "random":
+            sel.Random = true
+        case "pool", "session":
+            if i+1 >= len(parts) || parts[i+1] == "" {
+                return sel, fmt.Errorf("%w: missing value after %q", ErrInvalidSelector, part)
+            }
+            i++
+            if part == "pool" {
+                sel.Pool = parts[i]
+            } else {
+                sel.Session = parts[i]
+            }
+        default:
+            port, err := strconv.Atoi(part)
+            if err != nil || port < 1 || port > 65535 {
+                return sel, fmt.Errorf("%w: unknown part %q", ErrInvalidSelector, part)
+            }
+            sel.Port = port
+        }
+    }
+    
+    if sel.Port != 0 && (sel.Pool != "" || sel.Random) {
+        return sel, fmt.Errorf("%w: port cannot be combined with pool or random", ErrInvalidSelector)
+    }
+    return sel, nil
+}
+
+// String trả về dạng chuỗi của selector, dùng cho logging
+func (s Selector) String() string {
+    var parts []string
+    if s.Port != 0 {
+        parts = append(parts, strconv.Itoa(s.Port))
+    }
+    if s.Pool != "" {
+        parts = append(parts, "pool-"+s.Pool)
+    }
+    if s.Random {
+        parts = append(parts, "random")
+    }
+    if s.Session != "" {
+        parts = append(parts, "session-"+s.Session)
+    }
+    return strings.Join(parts, "-")
+}
+
+// sessionKey là khóa của session trong registry, gồm listener, tài khoản và selector
+func (s Selector) sessionKey() string {
+    return s.Listener + "/" + s.Account + "/" + s.String()
+}
@@ -0,0 +1,71 @@
+package upstream
+
+import (
+    "sync"
+    "time"
+)
+
+type sessionEntry struct {
+    upstream *Upstream
+    expires  time.Time
+}
+
+// sessionStore lưu upstream đã gán cho từng session (sticky routing),
+// session hết hạn sau ttl kể từ lần dùng cuối
+type sessionStore struct {
+    mu        sync.Mutex
+    ttl       time.Duration
+    entries   map[string]sessionEntry
+    lastSweep time.Time
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+    return &sessionStore{
+        ttl:       ttl,
+        entries:   make(map[string]sessionEntry),
+        lastSweep: time.Now(),
+    }
+}
+
+// get trả về upstream của session và gia hạn TTL
+func (s *sessionStore) get(key string) (*Upstream, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    now := time.Now()
+    entry, ok := s.entries[key]
+    if !ok || now.After(entry.expires) {
+        delete(s.entries, key)
+        return nil, false
+    }
+    
+    entry.expires = now.Add(s.ttl)
+    s.entries[key] = entry
+    return entry.upstream, true
+}
+
+// setTTL đổi thời gian sống của session, áp dụng từ lần dùng tiếp theo
+func (s *sessionStore) setTTL(ttl time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    s.ttl = ttl
+}
+
+func (s *sessionStore) set(key string, u *Upstream) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    now := time.Now()
+    s.entries[key] = sessionEntry{upstream: u, expires: now.Add(s.ttl)}
+    
+    // Dọn các session hết hạn định kỳ để map không phình mãi
+    if now.Sub(s.lastSweep) > time.Minute {
+        for k, e := range s.entries {
+            if now.After(e.expires) {
+                delete(s.entries, k)
+            }
+        }
+        s.lastSweep = now
+    }
+}
@@ -0,0 +1,105 @@
+package upstream
+
+import (
+    "fmt"
+    "math/rand"
+    "sync/atomic"
+)
+
+// Tên các chiến lược chọn upstream trong pool
+const (
+    StrategyRoundRobin       = "round-robin"
+    StrategyRandom           = "random"
+    StrategyLeastConnections = "least-connections"
+    StrategyLeastLatency     = "least-latency"
+    StrategyWeighted         = "weighted"
+)
+
+// Strategy chọn một upstream trong danh sách ứng viên (không rỗng)
+type Strategy interface {
+    Pick(candidates []*Upstream) *Upstream
+}
+
+// NewStrategy tạo strategy theo tên, tên rỗng tương đương round-robin
+func NewStrategy(name string) (Strategy, error) {
+    switch name {
+    case "", StrategyRoundRobin:
+        return &roundRobin{}, nil
+    case StrategyRandom:
+        return randomStrategy{}, nil
+    case StrategyLeastConnections:
+        return leastConnections{}, nil
+    case StrategyLeastLatency:
+        return leastLatency{}, nil
+    case StrategyWeighted:
+        return weighted{}, nil
+    }
+    return nil, fmt.Errorf("unknown strategy %q", name)
+}
+
+// ValidStrategy kiểm tra tên strategy khi load cấu hình
+func ValidStrategy(name string) bool {
+    _, err := NewStrategy(name)
+    return err == nil
+}
+
+type roundRobin struct {
+    next uint64
+}
+
+func (s *roundRobin) Pick(candidates []*Upstream) *Upstream {
+    n := atomic.AddUint64(&s.next, 1) - 1
+    return candidates[n%uint64(len(candidates))]
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Pick(candidates []*Upstream) *Upstream {
+    return candidates[rand.Intn(len(candidates))]
+}
+
+// leastConnections chọn upstream đang có ít request/tunnel nhất
+type leastConnections struct{}
+
+func (leastConnections) Pick(candidates []*Upstream) *Upstream {
+    best := candidates[0]
+    for _, u := range candidates[1:] {
+        if u.ActiveConns() < best.ActiveConns() {
+            best = u
+        }
+    }
+    return best
+}
+
+// leastLatency chọn upstream có latency trung bình thấp nhất,
+// upstream chưa có số liệu được ưu tiên để đo
+type leastLatency struct{}
+
+func (leastLatency) Pick(candidates []*Upstream) *Upstream {
+    best := candidates[0]
+    for _, u := range candidates[1:] {
+        if u.Latency() < best.Latency() {
+            best = u
+        }
+    }
+    return best
+}
+
+// weighted chọn ngẫu nhiên theo trọng số của upstream
+type weighted struct{}
+
+func (weighted) Pick(candidates []*Upstream) *Upstream {
+    total := 0
+    for _, u := range candidates {
+        total += u.Weight()
+    }
+    
+    n := rand.Intn(total)
+    for _, u := range candidates {
+        n -= u.Weight()
+        if n < 0 {
+            return u
+        }
+    }
+    return candidates[len(candidates)-1]
+}
@@ -0,0 +1,44 @@
+package upstream
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "proxy-server/config"
+    "time"
+)
+
+// NewTransport tạo http.Transport gửi request HTTP thông qua upstream proxy
+func NewTransport(cfg *config.UpstreamConfig, dialer Dialer, dialTimeout time.Duration) (*http.Transport, error) {
+    transport := &http.Transport{
+        DialContext: (&net.Dialer{
+            Timeout:   dialTimeout,
+            KeepAlive: 30 * time.Second,
+        }).DialContext,
+        MaxIdleConns:          100,
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   15 * time.Second,
+        ExpectContinueTimeout: 2 * time.Second,
+        TLSClientConfig: &tls.Config{
+            InsecureSkipVerify: false,
+            MinVersion:         tls.VersionTLS12,
+        },
+    }
+    
+    switch cfg.ProxyScheme {
+    case "", "http":
+        // HTTP upstream nhận request dạng absolute-form, Transport tự thêm Proxy-Authorization
+        proxyURL, err := url.Parse(cfg.ProxyURL)
+        if err != nil {
+            return nil, fmt.Errorf("parse proxy URL: %w", err)
+        }
+        transport.Proxy = http.ProxyURL(proxyURL)
+    default:
+        // SOCKS5 upstream: mọi kết nối tới destination đều đi qua dialer
+        transport.DialContext = dialer.DialContext
+    }
+    
+    return transport, nil
+}
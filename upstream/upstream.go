@@ -0,0 +1,185 @@
+package upstream
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "proxy-server/config"
+    "proxy-server/socks5"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// latencyAlpha là hệ số của trung bình trượt (EWMA) latency
+const latencyAlpha = 0.3
+
+// Upstream là một upstream proxy đã sẵn sàng để dial và gửi request HTTP
+type Upstream struct {
+    Name   string
+    Config *config.UpstreamConfig
+    Client *http.Client
+    dialer Dialer
+    
+    active    int64
+    latencyMu sync.Mutex
+    latency   time.Duration
+    
+    stateMu sync.Mutex
+    health  healthState
+    breaker *breaker
+}
+
+// New tạo Upstream từ cấu hình
+func New(cfg *config.UpstreamConfig, timeouts config.TimeoutConfig, breakerCfg config.BreakerConfig) (*Upstream, error) {
+    dialer, err := NewDialer(cfg, timeouts.Dial)
+    if err != nil {
+        return nil, err
+    }
+    
+    transport, err := NewTransport(cfg, dialer, timeouts.Dial)
+    if err != nil {
+        return nil, err
+    }
+    
+    client := &http.Client{
+        Transport: transport,
+        Timeout:   timeouts.Request,
+        // Redirect được trả nguyên cho client: proxy tự đi theo thì đích mới
+        // không qua ACL và destination guard
+        CheckRedirect: func(req *http.Request, via []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+    
+    name := cfg.Name
+    if name == "" {
+        name = cfg.GetProxyAddress()
+    }
+    
+    return &Upstream{
+        Name:    name,
+        Config:  cfg,
+        Client:  client,
+        dialer:  dialer,
+        health:  healthState{healthy: true},
+        breaker: newBreaker(name, breakerCfg),
+    }, nil
+}
+
+// DialContext mở kết nối TCP tới addr thông qua upstream,
+// ghi nhận latency và kết quả cho circuit breaker
+func (u *Upstream) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    if err := u.Allow(); err != nil {
+        return nil, err
+    }
+    
+    start := time.Now()
+    conn, err := u.dialer.DialContext(ctx, network, addr)
+    u.Report(err)
+    if err != nil {
+        return nil, err
+    }
+    
+    u.ObserveLatency(time.Since(start))
+    return conn, nil
+}
+
+// IsUpstreamFault phân biệt lỗi do upstream với lỗi do destination
+// (upstream vẫn hoạt động nhưng destination không kết nối được)
+func IsUpstreamFault(err error) bool {
+    var statusErr *StatusError
+    if errors.As(err, &statusErr) {
+        return statusErr.StatusCode == http.StatusProxyAuthRequired
+    }
+    
+    var replyErr *socks5.ReplyError
+    if errors.As(err, &replyErr) {
+        return replyErr.Code == socks5.ReplyGeneralFailure
+    }
+    return true
+}
+
+// Allow kiểm tra circuit breaker trước khi gửi request qua upstream,
+// mỗi lần Allow thành công phải được kết thúc bằng Report
+func (u *Upstream) Allow() error {
+    return u.breaker.allow()
+}
+
+// Report ghi nhận kết quả request cho circuit breaker. Lỗi do destination
+// được tính là upstream hoạt động bình thường, client hủy request thì không tính
+func (u *Upstream) Report(err error) {
+    switch {
+    case err == nil:
+        u.breaker.success()
+    case errors.Is(err, context.Canceled):
+        u.breaker.release()
+    case IsUpstreamFault(err):
+        u.breaker.failure()
+    default:
+        u.breaker.success()
+    }
+}
+
+// BreakerState trả về trạng thái circuit breaker của upstream
+func (u *Upstream) BreakerState() BreakerState {
+    return u.breaker.State()
+}
+
+// Healthy cho biết upstream có được dùng cho routing hay không:
+// health check phải đang healthy và circuit breaker còn nhận request
+func (u *Upstream) Healthy() bool {
+    u.stateMu.Lock()
+    healthy := u.health.healthy
+    u.stateMu.Unlock()
+    
+    return healthy && u.breaker.available()
+}
+
+// Track đánh dấu một request/tunnel đang dùng upstream, gọi hàm trả về khi kết thúc
+func (u *Upstream) Track() func() {
+    atomic.AddInt64(&u.active, 1)
+    var once sync.Once
+    return func() {
+        once.Do(func() { atomic.AddInt64(&u.active, -1) })
+    }
+}
+
+// ActiveConns trả về số request/tunnel đang dùng upstream
+func (u *Upstream) ActiveConns() int64 {
+    return atomic.LoadInt64(&u.active)
+}
+
+// ObserveLatency cập nhật latency trung bình của upstream
+func (u *Upstream) ObserveLatency(d time.Duration) {
+    u.latencyMu.Lock()
+    defer u.latencyMu.Unlock()
+    
+    if u.latency == 0 {
+        u.latency = d
+        return
+    }
+    u.latency = time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(u.latency))
+}
+
+// Latency trả về latency trung bình, 0 nếu chưa có số liệu
+func (u *Upstream) Latency() time.Duration {
+    u.latencyMu.Lock()
+    defer u.latencyMu.Unlock()
+    
+    return u.latency
+}
+
+// Weight trả về trọng số dùng cho strategy weighted
+func (u *Upstream) Weight() int {
+    if u.Config.Weight < 1 {
+        return 1
+    }
+    return u.Config.Weight
+}
+
+// Address trả về host:port của upstream
+func (u *Upstream) Address() string {
+    return u.Config.GetProxyAddress()
+}
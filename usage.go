@@ -0,0 +1,47 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "proxy-server/accounting"
+)
+
+// runUsage xuất lưu lượng từ file thống kê mà không cần server đang chạy:
+// proxy-forward usage --file usage.json [--from ...] [--to ...] [--format csv|json] [--by user,port,upstream]
+func runUsage(args []string) int {
+    flags := flag.NewFlagSet("usage", flag.ExitOnError)
+    file := flags.String("file", os.Getenv("ACCOUNTING_FILE"), "traffic accounting file (default: $ACCOUNTING_FILE)")
+    fromFlag := flags.String("from", "", "start of the window, RFC 3339 or YYYY-MM-DD (default: 24 hours before --to)")
+    toFlag := flags.String("to", "", "end of the window (exclusive), RFC 3339 or YYYY-MM-DD (default: end of the current hour)")
+    format := flags.String("format", "csv", "output format: csv or json")
+    byFlag := flags.String("by", "", "group by user, port and/or upstream, comma separated (default: all)")
+    flags.Parse(args)
+
+    if *file == "" {
+        fmt.Fprintln(os.Stderr, "usage: --file or ACCOUNTING_FILE is required")
+        return 2
+    }
+
+    from, to, err := accounting.Window(*fromFlag, *toFlag)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 2
+    }
+    by, err := accounting.ParseGrouping(*byFlag)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 2
+    }
+
+    records, err := accounting.QueryFile(*file, from, to, by)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    if err := accounting.Export(os.Stdout, *format, from, to, records); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 2
+    }
+    return 0
+}
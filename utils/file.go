@@ -0,0 +1,29 @@
+package utils
+
+import (
+    "os"
+    "path/filepath"
+)
+
+// WriteFileAtomic ghi data ra file tạm cùng thư mục rồi rename thành path,
+// file không bị hỏng nếu process dừng giữa chừng
+func WriteFileAtomic(path string, data []byte) error {
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
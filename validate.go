@@ -0,0 +1,26 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "proxy-server/config"
+    "proxy-server/upstream"
+)
+
+// runValidate kiểm tra file cấu hình và danh sách upstream mà không khởi động server,
+// in tất cả lỗi tìm được và trả về exit code
+func runValidate(configPath string) int {
+    cfg, err := config.Validate(configPath)
+    if err == nil {
+        // Strategy của pool và listener chỉ được kiểm tra khi tạo registry
+        _, err = upstream.NewRegistry(cfg)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        fmt.Fprintln(os.Stderr, "Configuration is invalid")
+        return 1
+    }
+    
+    fmt.Printf("Configuration is valid: %d upstreams, %d listeners\n", len(cfg.Upstreams), len(cfg.Listeners))
+    return 0
+}